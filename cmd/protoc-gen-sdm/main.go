@@ -10,15 +10,28 @@ import (
 
 func main() {
 	var flags flag.FlagSet
+	var opts generator.Options
+	flags.StringVar(&opts.Dialect, "dialect", generator.DialectPostgres, "SQL dialect of the generated schema: postgres, sqlite or mysql")
+	flags.StringVar(&opts.LedgerPayload, "ledger_payload", generator.LedgerPayloadFields, "what Save queues for the ledger: fields or merkle")
+	flags.StringVar(&opts.Backend, "backend", generator.BackendGORM, "database API of the generated repositories: gorm, sql or pgx")
+	flags.StringVar(&opts.Roles.PiiReader, "pii_reader_role", "", "Postgres role granted read access to the PII tables")
+	flags.StringVar(&opts.Roles.Public, "public_role", "", "Postgres role granted read access to the chain tables and redacted views only")
+	flags.BoolVar(&opts.MultiTenant, "multi_tenant", false, "scope every message by tenant")
+	flags.BoolVar(&opts.SoftDelete, "soft_delete", false, "mark deleted records deleted_at instead of removing them")
+	flags.StringVar(&opts.ChainPartition, "chain_partition", "", "partition the chain tables by range of created_at or by hash of key (postgres only)")
+	flags.IntVar(&opts.ChainPartitions, "chain_partitions", 0, "number of hash partitions of the chain tables (default 8)")
 	protogen.Options{
 		ParamFunc: flags.Set,
 	}.Run(func(gen *protogen.Plugin) error {
 		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		if err := opts.Validate(); err != nil {
+			return err
+		}
 		for _, f := range gen.Files {
 			if !f.Generate {
 				continue
 			}
-			generator.GenerateFile(gen, f)
+			generator.GenerateFile(gen, f, opts)
 		}
 		return nil
 	})
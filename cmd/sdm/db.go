@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+
+	"github.com/jinuthankachan/sdm/pkg/config"
+	"github.com/jinuthankachan/sdm/pkg/drift"
+	"github.com/jinuthankachan/sdm/pkg/generator"
+)
+
+var (
+	dsn         string
+	applyDryRun bool
+)
+
+// sqlDrivers are the database/sql drivers of the dialects.
+var sqlDrivers = map[string]string{
+	generator.DialectPostgres: "pgx",
+	generator.DialectSQLite:   "sqlite",
+	generator.DialectMySQL:    "mysql",
+}
+
+func newDBCmd() *cobra.Command {
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Work with a live database",
+	}
+	dbCmd.PersistentFlags().StringVar(&protoFile, "proto", "", "Input proto file")
+	dbCmd.PersistentFlags().StringVar(&cfgFile, "cfg", "sdm.cfg.yaml", "Config file sdm.cfg.yaml")
+	dbCmd.PersistentFlags().StringVar(&dsn, "dsn", "", "Database connection string (overrides config)")
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Report drift between a Postgres database and the protos",
+		Long: `Check introspects the pii_*, chain_* tables and the views of a Postgres
+database and reports where they differ from the schema the current protos
+generate: missing tables, columns and indexes, unexpected columns, wrong
+column types and stale views. It exits non-zero on drift.`,
+		RunE:         runDBCheck,
+		SilenceUsage: true,
+	}
+
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Apply pending migrations, or the generated schema, to a database",
+		Long: `Apply executes the migrations of the migrations directory that the
+database has not applied yet, in order and in a single transaction, and
+records them in the sdm_schema_migrations table. Without migrations, it
+applies the schema the current protos generate.
+
+MySQL commits DDL statements implicitly, so a failed apply is only rolled
+back on Postgres and SQLite.`,
+		RunE:         runDBApply,
+		SilenceUsage: true,
+	}
+	applyCmd.Flags().StringVar(&dialect, "dialect", "", "SQL dialect of the database: postgres, sqlite or mysql (overrides config)")
+	applyCmd.Flags().StringVar(&migrationsDir, "dir", "", "Migrations directory (overrides config, default migrations/)")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Print the statements that would be applied instead of applying them")
+
+	dbCmd.AddCommand(checkCmd, applyCmd)
+	return dbCmd
+}
+
+func runDBCheck(cmd *cobra.Command, args []string) error {
+	cfg, configDir, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if name := resolveDialect(cfg); name != "" && name != generator.DialectPostgres {
+		return fmt.Errorf("db check supports postgres only, not %s", name)
+	}
+	d, err := generator.LookupDialect(generator.DialectPostgres)
+	if err != nil {
+		return err
+	}
+	current, err := protoSnapshot(cfg, configDir, d)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg, d)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	diffs, err := drift.Check(context.Background(), db, current.Tables)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		fmt.Println("No drift")
+		return nil
+	}
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	return fmt.Errorf("found %d differences from the generated schema", len(diffs))
+}
+
+func runDBApply(cmd *cobra.Command, args []string) error {
+	cfg, configDir, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	d, err := generator.LookupDialect(resolveDialect(cfg))
+	if err != nil {
+		return err
+	}
+	migrations, err := readMigrations(resolveMigrationsDir(cfg, configDir))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if len(migrations) == 0 {
+		current, err := protoSnapshot(cfg, configDir, d)
+		if err != nil {
+			return err
+		}
+		var stmts []string
+		roles := current.Roles
+		// Tables follow the tables their foreign keys reference.
+		for _, t := range generator.OrderByReferences(current.Tables) {
+			stmts = append(stmts, generator.SchemaStatements(d, t)...)
+			stmts = append(stmts, generator.AccessStatements(d, t, roles)...)
+		}
+		fmt.Println("No migrations; applying the generated schema")
+		if applyDryRun {
+			// The schema does not depend on the database, which is not
+			// connected to.
+			if err := execStatements(ctx, nil, stmts); err != nil {
+				return err
+			}
+			return finishApply(nil)
+		}
+		db, err := openDB(cfg, d)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// Commit below makes the rollback a no-op.
+		defer tx.Rollback()
+		if err := execStatements(ctx, tx, stmts); err != nil {
+			return err
+		}
+		return finishApply(tx)
+	}
+
+	db, err := openDB(cfg, d)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// A dry run only reads the database: it runs no statement, not even the
+	// CREATE TABLE of the migrations table, which MySQL would commit.
+	var tx *sql.Tx
+	var q queryer = db
+	if !applyDryRun {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// Commit below makes the rollback a no-op.
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, generator.CreateMigrationsTable(d)); err != nil {
+			return fmt.Errorf("failed to create %s: %w", generator.MigrationsTable, err)
+		}
+		q = tx
+	}
+	applied, err := appliedMigrations(ctx, q, d)
+	if err != nil {
+		return err
+	}
+	record := fmt.Sprintf("INSERT INTO %s (version, name) VALUES (%s, %s)", generator.MigrationsTable, d.Placeholder(1), d.Placeholder(2))
+
+	pending := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		pending++
+		script, err := os.ReadFile(m.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applying %s\n", m.Name)
+		if err := execStatements(ctx, tx, splitStatements(string(script))); err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		if applyDryRun {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, record, m.Version, m.Name); err != nil {
+			return fmt.Errorf("failed to record %s: %w", m.Name, err)
+		}
+	}
+	if pending == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+	return finishApply(tx)
+}
+
+// queryer is a *sql.DB or *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// appliedMigrations returns the versions recorded in the migrations table,
+// none if it does not exist yet.
+func appliedMigrations(ctx context.Context, q queryer, d generator.Dialect) (map[int]bool, error) {
+	var tables int
+	if err := q.QueryRowContext(ctx, generator.MigrationsTableExists(d)).Scan(&tables); err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", generator.MigrationsTable, err)
+	}
+	applied := make(map[int]bool)
+	if tables == 0 {
+		return applied, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT version FROM "+generator.MigrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", generator.MigrationsTable, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// execStatements executes stmts in tx, or prints them on a dry run, which
+// passes no tx.
+func execStatements(ctx context.Context, tx *sql.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if applyDryRun {
+			fmt.Printf("%s;\n\n", stmt)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute %q: %w", firstLine(stmt), err)
+		}
+	}
+	return nil
+}
+
+func finishApply(tx *sql.Tx) error {
+	if applyDryRun {
+		fmt.Println("Dry run: nothing applied")
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	fmt.Println("Applied")
+	return nil
+}
+
+func firstLine(stmt string) string {
+	if i := strings.IndexByte(stmt, '\n'); i >= 0 {
+		return stmt[:i] + " ..."
+	}
+	return stmt
+}
+
+// openDB connects to the database of --dsn or the config with the driver
+// of dialect d.
+func openDB(cfg *config.Config, d generator.Dialect) (*sql.DB, error) {
+	source := dsn
+	if source == "" {
+		source = os.ExpandEnv(cfg.DSN)
+	}
+	if source == "" {
+		return nil, fmt.Errorf("no database: pass --dsn or set dsn in the config")
+	}
+	db, err := sql.Open(sqlDrivers[d.Name()], source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
 	"github.com/spf13/cobra"
 	"google.golang.org/protobuf/compiler/protogen"
 	"google.golang.org/protobuf/proto"
@@ -26,6 +27,7 @@ var (
 	cfgFile   string
 	protoFile string
 	outputDir string
+	dialect   string
 	version   = "dev"
 )
 
@@ -68,10 +70,14 @@ func newRootCmd() *cobra.Command {
 	generateCmd.Flags().StringVar(&outputDir, "out", "", "Directory to output the generated files")
 	generateCmd.Flags().StringVar(&protoFile, "proto", "", "Input proto file")
 	generateCmd.Flags().StringVar(&cfgFile, "cfg", "sdm.cfg.yaml", "Config file sdm.cfg.yaml")
+	generateCmd.Flags().StringVar(&dialect, "dialect", "", "SQL dialect of the generated schema: postgres, sqlite or mysql (overrides config)")
 
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(setupCmd)
 	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(newVerifyCmd())
+	rootCmd.AddCommand(newMigrateCmd())
+	rootCmd.AddCommand(newDBCmd())
 
 	return rootCmd
 }
@@ -153,6 +159,42 @@ sdm: "%s"
 
 # Directory where to write the generated SQL files (defaults to output if not set)
 # output-sql: "gen/sql/"
+
+# SQL dialect of the generated schema: "postgres", "sqlite" or "mysql"
+# dialect: "postgres"
+
+# Directory of the versioned migrations written by 'sdm migrate'
+# migrations: "migrations/"
+
+# What Save queues for the ledger: "fields" (canonical chain payload) or "merkle" (Merkle root only)
+# ledger-payload: "fields"
+
+# Database API of the generated repositories: "gorm", "sql" (database/sql only)
+# or "pgx" (postgres only)
+# backend: "gorm"
+
+# Database checked by 'sdm db check'; environment variables are expanded
+# dsn: "${DATABASE_URL}"
+
+# Scope every message by tenant_id, read from the context (or annotate a file
+# with option (sdm.multi_tenant) = true)
+# multi-tenant: true
+
+# Give every message a Delete that marks records deleted_at, hiding them from
+# the views, and a Restore (or annotate a file with option
+# (sdm.soft_delete) = true)
+# soft-delete: true
+
+# Partition the chain tables (Postgres only): "range" by created_at, with a
+# default partition to which you add ranges, or "hash" by record key
+# chain-partition: "hash"
+# chain-partitions: 8
+
+# Postgres roles granted access to the generated tables: the PII reader may
+# read everything, the public role only the chain tables and redacted views
+# roles:
+#   pii-reader: "pii_reader"
+#   public: "sdm_public"
 `, version)
 	if err := os.WriteFile("sdm.cfg.yaml", []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write sdm.cfg.yaml: %w", err)
@@ -232,6 +274,90 @@ directories:
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
+	cfg, configDir, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	req, _, err := compileProtos(cfg, configDir)
+	if err != nil {
+		return err
+	}
+
+	// Determine output
+	out := outputDir
+	if out == "" {
+		out = cfg.Output
+		if out != "" && !filepath.IsAbs(out) {
+			out = filepath.Join(configDir, out)
+		}
+	}
+
+	outSQL := cfg.OutputSQL
+	if outSQL != "" && !filepath.IsAbs(outSQL) {
+		outSQL = filepath.Join(configDir, outSQL)
+	}
+	if outSQL == "" {
+		outSQL = out
+	}
+
+	genOpts := generatorOptions(cfg)
+	if err := genOpts.Validate(); err != nil {
+		return err
+	}
+
+	opts := protogen.Options{}
+	gen, err := opts.New(req)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin: %w", err)
+	}
+
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		generator.GenerateFile(gen, f, genOpts)
+	}
+
+	response := gen.Response()
+	if response.Error != nil {
+		return fmt.Errorf("generator error: %s", response.GetError())
+	}
+
+	// Run protoc-gen-go
+	if err := runProtocGenGo(req, response); err != nil {
+		return fmt.Errorf("failed to run protoc-gen-go: %w", err)
+	}
+
+	for _, file := range response.File {
+		name := file.GetName()
+		targetDir := out
+		if strings.HasSuffix(name, ".sql") {
+			targetDir = outSQL
+			// Flatten the path for SQL files and prepend timestamp
+			name = fmt.Sprintf("%s", filepath.Base(name))
+		}
+
+		if targetDir != "" {
+			name = filepath.Join(targetDir, name)
+		}
+
+		content := file.GetContent()
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", name, err)
+		}
+		fmt.Printf("Generated: %s\n", name)
+	}
+
+	return nil
+}
+
+// loadConfig loads the --cfg file and returns it with the directory its
+// paths are relative to. A missing config file yields an empty config.
+func loadConfig() (*config.Config, string, error) {
 	// Parse config
 	cfg, err := config.LoadConfig(cfgFile)
 	if err != nil {
@@ -239,14 +365,20 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		// However, if user provides --proto and --out, they don't need config for that specific run
 		// Let's treat config as optional if flags are provided".
 		if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to load config %s: %w", cfgFile, err)
+			return nil, "", fmt.Errorf("failed to load config %s: %w", cfgFile, err)
 		}
 		cfg = &config.Config{} // Empty config
 	}
 
-	// Determine inputs
 	absCfgFile, _ := filepath.Abs(cfgFile)
-	configDir := filepath.Dir(absCfgFile)
+	return cfg, filepath.Dir(absCfgFile), nil
+}
+
+// compileProtos compiles the proto files given by --proto or the config
+// user-protos and returns the plugin request generating them along with the
+// compiled files.
+func compileProtos(cfg *config.Config, configDir string) (*pluginpb.CodeGeneratorRequest, linker.Files, error) {
+	// Determine inputs
 	var filesToGenerate []string
 
 	// // Resolve Source directory
@@ -270,24 +402,7 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 	}
 
 	if len(filesToGenerate) == 0 {
-		return fmt.Errorf("no proto files specified (use --proto or config user-protos)")
-	}
-
-	// Determine output
-	out := outputDir
-	if out == "" {
-		out = cfg.Output
-		if out != "" && !filepath.IsAbs(out) {
-			out = filepath.Join(configDir, out)
-		}
-	}
-
-	outSQL := cfg.OutputSQL
-	if outSQL != "" && !filepath.IsAbs(outSQL) {
-		outSQL = filepath.Join(configDir, outSQL)
-	}
-	if outSQL == "" {
-		outSQL = out
+		return nil, nil, fmt.Errorf("no proto files specified (use --proto or config user-protos)")
 	}
 
 	sdmProtoDir := cfg.SdmProto
@@ -346,11 +461,9 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 
 	files, err := compiler.Compile(context.Background(), filesToGenerate...)
 	if err != nil {
-		return fmt.Errorf("failed to compile protos: %w", err)
+		return nil, nil, fmt.Errorf("failed to compile protos: %w", err)
 	}
 
-	// ... (Rest of generation logic similar to before) ...
-
 	req := &pluginpb.CodeGeneratorRequest{
 		FileToGenerate: filesToGenerate,
 		Parameter:      proto.String("paths=source_relative"),
@@ -374,53 +487,7 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		collect(f)
 	}
 
-	opts := protogen.Options{}
-	gen, err := opts.New(req)
-	if err != nil {
-		return fmt.Errorf("failed to create plugin: %w", err)
-	}
-
-	for _, f := range gen.Files {
-		if !f.Generate {
-			continue
-		}
-		generator.GenerateFile(gen, f)
-	}
-
-	response := gen.Response()
-	if response.Error != nil {
-		return fmt.Errorf("generator error: %s", response.GetError())
-	}
-
-	// Run protoc-gen-go
-	if err := runProtocGenGo(req, response); err != nil {
-		return fmt.Errorf("failed to run protoc-gen-go: %w", err)
-	}
-
-	for _, file := range response.File {
-		name := file.GetName()
-		targetDir := out
-		if strings.HasSuffix(name, ".sql") {
-			targetDir = outSQL
-			// Flatten the path for SQL files and prepend timestamp
-			name = fmt.Sprintf("%s", filepath.Base(name))
-		}
-
-		if targetDir != "" {
-			name = filepath.Join(targetDir, name)
-		}
-
-		content := file.GetContent()
-		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
-			return err
-		}
-		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
-			return fmt.Errorf("failed to write file %s: %w", name, err)
-		}
-		fmt.Printf("Generated: %s\n", name)
-	}
-
-	return nil
+	return req, files, nil
 }
 
 func runProtocGenGo(req *pluginpb.CodeGeneratorRequest, resp *pluginpb.CodeGeneratorResponse) error {
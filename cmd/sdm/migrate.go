@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/compiler/protogen"
+
+	"github.com/jinuthankachan/sdm/pkg/config"
+	"github.com/jinuthankachan/sdm/pkg/generator"
+)
+
+// snapshotFile is the name of the schema snapshot kept in the migrations directory.
+const snapshotFile = "sdm_schema.json"
+
+var (
+	migrationsDir string
+	migrationName string
+	allowDrop     bool
+	migrateDryRun bool
+)
+
+func newMigrateCmd() *cobra.Command {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Generate versioned up/down SQL migrations from proto changes",
+		Long: `Migrate compares the schema the current protos generate with the snapshot
+stored in the migrations directory, writes the difference as numbered
+<version>_<name>.up.sql and .down.sql files (the layout of golang-migrate)
+and updates the snapshot.
+
+Fields whose classification changes (a chain field becoming pii, a field
+gaining hashed, ...) get a data migration too: the latest value moves to its
+new table, plaintext chain history is hashed or purged, and the change is
+recorded in the sdm_reclassifications table. --dry-run prints the migration
+and the number of rows it affects, counted in the database of --dsn or the
+config, without writing anything; without a database it prints the queries
+counting them instead.`,
+		RunE:         runMigrate,
+		SilenceUsage: true,
+	}
+	migrateCmd.Flags().StringVar(&protoFile, "proto", "", "Input proto file")
+	migrateCmd.Flags().StringVar(&cfgFile, "cfg", "sdm.cfg.yaml", "Config file sdm.cfg.yaml")
+	migrateCmd.Flags().StringVar(&dialect, "dialect", "", "SQL dialect of the migrations: postgres, sqlite or mysql (overrides config)")
+	migrateCmd.Flags().StringVar(&migrationsDir, "dir", "", "Migrations directory (overrides config, default migrations/)")
+	migrateCmd.Flags().StringVar(&migrationName, "name", "schema", "Name of the migration")
+	migrateCmd.Flags().BoolVar(&allowDrop, "allow-drop", false, "Allow dropping the tables of messages no longer in the protos")
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the migration and the reclassified fields instead of writing them")
+	migrateCmd.Flags().StringVar(&dsn, "dsn", "", "Database --dry-run counts the affected rows in (overrides config)")
+	return migrateCmd
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	cfg, configDir, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	d, err := generator.LookupDialect(resolveDialect(cfg))
+	if err != nil {
+		return err
+	}
+	current, err := protoSnapshot(cfg, configDir, d)
+	if err != nil {
+		return err
+	}
+
+	dir := resolveMigrationsDir(cfg, configDir)
+	previous, err := loadSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	if previous.Dialect != "" && previous.Dialect != d.Name() {
+		return fmt.Errorf("snapshot in %s was generated for %s, not %s", dir, previous.Dialect, d.Name())
+	}
+	if !allowDrop {
+		for _, t := range previous.Tables {
+			if !hasTable(current, t.Name) {
+				return fmt.Errorf("message %s is no longer generated; pass --allow-drop to drop its tables", t.Message)
+			}
+		}
+	}
+
+	m, err := generator.Diff(d, previous, current)
+	if err != nil {
+		return err
+	}
+	if m.Empty() {
+		fmt.Println("No schema changes")
+		return nil
+	}
+	if migrateDryRun {
+		return printDryRun(cfg, d, m)
+	}
+
+	version, err := nextMigrationVersion(dir)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%06d_%s", version, migrationSlug(migrationName)))
+	if err := writeStatements(base+".up.sql", m.Up); err != nil {
+		return err
+	}
+	if err := writeStatements(base+".down.sql", m.Down); err != nil {
+		return err
+	}
+	if err := saveSnapshot(dir, current); err != nil {
+		return err
+	}
+	fmt.Printf("Generated: %s.up.sql\n", base)
+	fmt.Printf("Generated: %s.down.sql\n", base)
+	for _, r := range m.Reclassifications {
+		if r.Fallback != "" {
+			fmt.Printf("Warning: %s: %s\n", r, r.Fallback)
+		}
+	}
+	return nil
+}
+
+// printDryRun prints the up migration and a report of the reclassified
+// fields with the number of rows their data migrations affect, counted in
+// the database of --dsn or the config. Without a database, it prints the
+// queries counting them instead.
+func printDryRun(cfg *config.Config, d generator.Dialect, m generator.Migration) error {
+	fmt.Println("-- Up migration")
+	for _, stmt := range m.Up {
+		fmt.Printf("%s;\n\n", stmt)
+	}
+	if len(m.Reclassifications) == 0 {
+		fmt.Println("-- No reclassified fields")
+		return nil
+	}
+
+	var db *sql.DB
+	if dsn != "" || os.ExpandEnv(cfg.DSN) != "" {
+		var err error
+		if db, err = openDB(cfg, d); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	fmt.Println("-- Reclassified fields")
+	if db == nil {
+		fmt.Println("-- No database (pass --dsn or set dsn in the config): rows were not counted.")
+	}
+	for _, r := range m.Reclassifications {
+		fmt.Printf("-- %s\n", r)
+		if r.Fallback != "" {
+			fmt.Printf("--   warning: %s\n", r.Fallback)
+		}
+		if db == nil {
+			fmt.Printf("--   affected rows: not counted, run %s;\n", r.CountQuery)
+			continue
+		}
+		var n int64
+		if err := db.QueryRowContext(context.Background(), r.CountQuery).Scan(&n); err != nil {
+			return fmt.Errorf("failed to count the rows of %s.%s: %w", r.Message, r.Field, err)
+		}
+		fmt.Printf("--   affected rows: %d\n", n)
+	}
+	return nil
+}
+
+// protoSnapshot returns the schema the protos of the config generate in d.
+func protoSnapshot(cfg *config.Config, configDir string, d generator.Dialect) (generator.Snapshot, error) {
+	req, _, err := compileProtos(cfg, configDir)
+	if err != nil {
+		return generator.Snapshot{}, err
+	}
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		return generator.Snapshot{}, fmt.Errorf("failed to create plugin: %w", err)
+	}
+	opts := generatorOptions(cfg)
+	opts.Dialect = d.Name()
+	if err := opts.Validate(); err != nil {
+		return generator.Snapshot{}, err
+	}
+	return generator.NewSnapshot(opts, gen.Files), nil
+}
+
+// generatorOptions returns the generator options of the config.
+func generatorOptions(cfg *config.Config) generator.Options {
+	return generator.Options{
+		LedgerPayload:   cfg.LedgerPayload,
+		Backend:         cfg.Backend,
+		Dialect:         resolveDialect(cfg),
+		Roles:           generator.Roles(cfg.Roles),
+		MultiTenant:     cfg.MultiTenant,
+		SoftDelete:      cfg.SoftDelete,
+		ChainPartition:  cfg.ChainPartition,
+		ChainPartitions: cfg.ChainPartitions,
+	}
+}
+
+// resolveDialect returns the SQL dialect selected by --dialect or the config.
+func resolveDialect(cfg *config.Config) string {
+	if dialect != "" {
+		return dialect
+	}
+	return cfg.Dialect
+}
+
+func resolveMigrationsDir(cfg *config.Config, configDir string) string {
+	if migrationsDir != "" {
+		return migrationsDir
+	}
+	dir := cfg.Migrations
+	if dir == "" {
+		dir = "migrations"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(configDir, dir)
+	}
+	return dir
+}
+
+func hasTable(s generator.Snapshot, name string) bool {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSnapshot reads the snapshot of dir; a missing snapshot is empty.
+func loadSnapshot(dir string) (generator.Snapshot, error) {
+	var s generator.Snapshot
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("failed to parse snapshot %s: %w", filepath.Join(dir, snapshotFile), err)
+	}
+	return s, nil
+}
+
+func saveSnapshot(dir string, s generator.Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+
+// nextMigrationVersion returns the version following the highest one in dir.
+func nextMigrationVersion(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	latest := 0
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		if v, err := strconv.Atoi(match[1]); err == nil && v > latest {
+			latest = v
+		}
+	}
+	return latest + 1, nil
+}
+
+// migrationFile is an up migration of the migrations directory.
+type migrationFile struct {
+	Version int
+	Name    string
+	Path    string
+}
+
+// readMigrations returns the up migrations of dir by version.
+func readMigrations(dir string) ([]migrationFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []migrationFile
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if match == nil || match[2] != "up" {
+			continue
+		}
+		v, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", e.Name(), err)
+		}
+		files = append(files, migrationFile{
+			Version: v,
+			Name:    strings.TrimSuffix(e.Name(), ".up.sql"),
+			Path:    filepath.Join(dir, e.Name()),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	for i := 1; i < len(files); i++ {
+		if files[i].Version == files[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", files[i-1].Name, files[i].Name)
+		}
+	}
+	return files, nil
+}
+
+// splitStatements splits a migration into its statements, each ending with
+// a semicolon at the end of a line.
+func splitStatements(script string) []string {
+	var stmts []string
+	var current []string
+	for _, line := range strings.Split(script, "\n") {
+		if len(current) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		current = append(current, line)
+		if trimmed := strings.TrimRight(line, " \t\r"); strings.HasSuffix(trimmed, ";") {
+			stmt := strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";")
+			if strings.TrimSpace(stmt) != "" {
+				stmts = append(stmts, stmt)
+			}
+			current = nil
+		}
+	}
+	if stmt := strings.TrimSpace(strings.Join(current, "\n")); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+var nonSlugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func migrationSlug(name string) string {
+	slug := strings.Trim(nonSlugRe.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "schema"
+	}
+	return slug
+}
+
+func writeStatements(path string, stmts []string) error {
+	var b strings.Builder
+	for _, stmt := range stmts {
+		b.WriteString(stmt)
+		b.WriteString(";\n\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
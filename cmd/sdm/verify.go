@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/jinuthankachan/sdm/pkg/generator"
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+)
+
+var (
+	messageName string
+	recordFile  string
+)
+
+func newVerifyCmd() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the plaintext of a record against its published hashes",
+		Long: `Verify reads a record as JSON, with its plaintext fields and the published
+hashed_<field> values (as returned by the view), recomputes the hash of every
+hashed field and reports the fields that do not match.`,
+		RunE:         runVerify,
+		SilenceUsage: true,
+	}
+	verifyCmd.Flags().StringVar(&protoFile, "proto", "", "Input proto file")
+	verifyCmd.Flags().StringVar(&cfgFile, "cfg", "sdm.cfg.yaml", "Config file sdm.cfg.yaml")
+	verifyCmd.Flags().StringVar(&messageName, "message", "", "Full name of the record message (optional if the protos define a single message)")
+	verifyCmd.Flags().StringVar(&recordFile, "record", "-", "JSON record to verify, - for stdin")
+	return verifyCmd
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	cfg, configDir, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	_, files, err := compileProtos(cfg, configDir)
+	if err != nil {
+		return err
+	}
+	md, err := findMessage(files, messageName)
+	if err != nil {
+		return err
+	}
+
+	data, err := readRecord(recordFile)
+	if err != nil {
+		return err
+	}
+	record, published, err := parseRecord(md, data)
+	if err != nil {
+		return err
+	}
+
+	mismatches := ledger.CompareHashes(hashedFields(record), published)
+	failed := make(map[string]ledger.Mismatch, len(mismatches))
+	for _, m := range mismatches {
+		failed[m.Field] = m
+	}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !generator.FieldOptions(fd).Hashed {
+			continue
+		}
+		m, ok := failed[string(fd.Name())]
+		switch {
+		case !ok:
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: ok\n", fd.Name())
+		case m.Published == "":
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: no published hash (computed %s)\n", fd.Name(), m.Computed)
+		default:
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: MISMATCH (published %s, computed %s)\n", fd.Name(), m.Published, m.Computed)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d hashed field(s) of %s do not match", len(mismatches), md.FullName())
+	}
+	return nil
+}
+
+// findMessage returns the message named name among files, or their single
+// message when name is empty.
+func findMessage(files linker.Files, name string) (protoreflect.MessageDescriptor, error) {
+	if name != "" {
+		for _, f := range files {
+			if md := f.Messages().ByName(protoreflect.FullName(name).Name()); md != nil && string(md.FullName()) == name {
+				return md, nil
+			}
+		}
+		return nil, fmt.Errorf("message %s not found", name)
+	}
+
+	var found []protoreflect.MessageDescriptor
+	for _, f := range files {
+		for i := 0; i < f.Messages().Len(); i++ {
+			found = append(found, f.Messages().Get(i))
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("found %d messages, use --message to choose one", len(found))
+	}
+	return found[0], nil
+}
+
+func readRecord(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// parseRecord splits a JSON record into the message it describes and its
+// published hashes, keyed by chain field name.
+func parseRecord(md protoreflect.MessageDescriptor, data []byte) (*dynamicpb.Message, map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse record: %w", err)
+	}
+
+	published := make(map[string]string)
+	fields := make(map[string]json.RawMessage)
+	for key, value := range raw {
+		if !strings.HasPrefix(key, ledger.HashedPrefix) {
+			fields[key] = value
+			continue
+		}
+		var hash string
+		if err := json.Unmarshal(value, &hash); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		published[key] = hash
+	}
+
+	// View columns outside of the message (tx_hash, chain_status, ...) are ignored.
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	record := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, record); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse record as %s: %w", md.FullName(), err)
+	}
+	return record, published, nil
+}
+
+// hashedFields returns the hash companions of the hashed fields of record,
+// computed the way generated ChainFields methods do.
+func hashedFields(record *dynamicpb.Message) []ledger.Field {
+	var hashed []ledger.Field
+	fields := record.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !generator.FieldOptions(fd).Hashed {
+			continue
+		}
+		hashed = append(hashed, ledger.Field{
+			Name:  ledger.HashedPrefix + string(fd.Name()),
+			Value: ledger.Hash(formatValue(fd, record.Get(fd))),
+		})
+	}
+	return hashed
+}
+
+// formatValue formats a field value like fmt.Sprintf("%v") does on the
+// corresponding generated Go getter.
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Kind() == protoreflect.EnumKind && !fd.IsList() {
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprintf("%d", v.Enum())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
@@ -13,6 +13,40 @@ type Config struct {
 	UserProtos []string `yaml:"user-protos"`
 	Output     string   `yaml:"output"`
 	OutputSQL  string   `yaml:"output-sql"`
+	Migrations string   `yaml:"migrations"`
+
+	Dialect       string `yaml:"dialect"`
+	LedgerPayload string `yaml:"ledger-payload"`
+	// Backend is the database API of the generated repositories: "gorm"
+	// (default), "sql" or "pgx".
+	Backend string `yaml:"backend,omitempty"`
+
+	// DSN is the database the db commands connect to. Environment variables
+	// in it are expanded.
+	DSN string `yaml:"dsn"`
+
+	// MultiTenant scopes every message by tenant.
+	MultiTenant bool `yaml:"multi-tenant,omitempty"`
+
+	// SoftDelete marks deleted records deleted_at instead of removing them.
+	SoftDelete bool `yaml:"soft-delete,omitempty"`
+
+	// ChainPartition partitions the chain tables by "range" of created_at
+	// or by "hash" of the record key (Postgres only).
+	ChainPartition string `yaml:"chain-partition,omitempty"`
+	// ChainPartitions is the number of hash partitions.
+	ChainPartitions int `yaml:"chain-partitions,omitempty"`
+
+	// Roles are granted access to the generated tables (Postgres only).
+	Roles Roles `yaml:"roles,omitempty"`
+}
+
+// Roles names the database roles of the generated grants and policies.
+type Roles struct {
+	// PiiReader may read the PII tables and the full views.
+	PiiReader string `yaml:"pii-reader"`
+	// Public may read only the chain tables and the redacted views.
+	Public string `yaml:"public"`
 }
 
 func LoadConfig(path string) (*Config, error) {
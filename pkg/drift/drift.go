@@ -0,0 +1,212 @@
+// Package drift compares the schema of a live Postgres database with the
+// schema SDM generates for a set of messages.
+package drift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinuthankachan/sdm/pkg/generator"
+)
+
+// Kinds of differences.
+const (
+	MissingTable   = "missing table"
+	MissingColumn  = "missing column"
+	ExtraColumn    = "unexpected column"
+	WrongType      = "wrong type"
+	MissingIndex   = "missing index"
+	MissingView    = "missing view"
+	StaleView      = "stale view"
+	UnexpectedView = "not a view"
+)
+
+// Difference is a way the database differs from the generated schema.
+type Difference struct {
+	// Object is the table, view or index that differs.
+	Object string
+	Kind   string
+	Detail string
+}
+
+func (d Difference) String() string {
+	if d.Detail == "" {
+		return d.Object + ": " + d.Kind
+	}
+	return d.Object + ": " + d.Kind + ": " + d.Detail
+}
+
+// pgTypes maps the column types of the Postgres dialect to the data types
+// information_schema reports for them.
+var pgTypes = map[string]string{
+	"TEXT":      "text",
+	"BIGINT":    "bigint",
+	"INTEGER":   "integer",
+	"BYTEA":     "bytea",
+	"TIMESTAMP": "timestamp without time zone",
+}
+
+// Check compares the PII tables, chain tables and views of tables in the
+// current schema of db with the Postgres schema generated for them.
+func Check(ctx context.Context, db *sql.DB, tables []generator.Table) ([]Difference, error) {
+	d, err := generator.LookupDialect(generator.DialectPostgres)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+	for _, t := range tables {
+		found, err := checkColumns(ctx, db, t.PiiTable(), generator.PiiColumns(d, t))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, found...)
+
+		found, err = checkColumns(ctx, db, t.ChainTable(), generator.ChainColumns(d, t))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, found...)
+
+		var indexes []string
+		for _, f := range t.Fields {
+			if f.QueryIndex && !f.PrimaryKey {
+				indexes = append(indexes, t.IndexName(f.Name))
+			}
+		}
+		found, err = checkIndexes(ctx, db, t.PiiTable(), indexes)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, found...)
+
+		if t.ChainPartition != "" {
+			found, err = checkIndexes(ctx, db, t.ChainTable(), []string{t.CompactIndex()})
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, found...)
+		}
+
+		found, err = checkView(ctx, db, t.View(), generator.ViewVersion(generator.ViewQuery(d, t)))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, found...)
+	}
+	return diffs, nil
+}
+
+func checkColumns(ctx context.Context, db *sql.DB, table string, want []generator.Column) ([]Difference, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	have := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		have[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(have) == 0 {
+		return []Difference{{Object: table, Kind: MissingTable}}, nil
+	}
+
+	var diffs []Difference
+	expected := make(map[string]bool, len(want))
+	for _, c := range want {
+		expected[c.Name] = true
+		typ, ok := have[c.Name]
+		if !ok {
+			diffs = append(diffs, Difference{Object: table, Kind: MissingColumn, Detail: c.Name})
+			continue
+		}
+		if wantType := pgType(c.Type); typ != wantType {
+			diffs = append(diffs, Difference{Object: table, Kind: WrongType, Detail: fmt.Sprintf("%s is %s, want %s", c.Name, typ, wantType)})
+		}
+	}
+	var extra []string
+	for name := range have {
+		if !expected[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		diffs = append(diffs, Difference{Object: table, Kind: ExtraColumn, Detail: name})
+	}
+	return diffs, nil
+}
+
+func pgType(typ string) string {
+	if t, ok := pgTypes[typ]; ok {
+		return t
+	}
+	return strings.ToLower(typ)
+}
+
+func checkIndexes(ctx context.Context, db *sql.DB, table string, want []string) ([]Difference, error) {
+	if len(want) == 0 {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = $1`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the indexes of %s: %w", table, err)
+	}
+	defer rows.Close()
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		have[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+	for _, name := range want {
+		if !have[name] {
+			diffs = append(diffs, Difference{Object: table, Kind: MissingIndex, Detail: name})
+		}
+	}
+	return diffs, nil
+}
+
+// checkView compares the version the view is commented with to the version
+// of the generated view.
+func checkView(ctx context.Context, db *sql.DB, view, version string) ([]Difference, error) {
+	var kind string
+	var comment sql.NullString
+	err := db.QueryRowContext(ctx,
+		`SELECT c.relkind::text, obj_description(c.oid, 'pg_class') FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relname = $1`, view).Scan(&kind, &comment)
+	if err == sql.ErrNoRows {
+		return []Difference{{Object: view, Kind: MissingView}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read view %s: %w", view, err)
+	}
+	if kind != "v" {
+		return []Difference{{Object: view, Kind: UnexpectedView}}, nil
+	}
+	if comment.String != version {
+		return []Difference{{Object: view, Kind: StaleView, Detail: "definition differs from the generated view"}}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AccessStatements returns the statements restricting access to the tables
+// of t to roles: the PII reader may read everything, the public role only
+// the chain table and the redacted view. Row-level security on the PII and
+// chain tables denies every other role that is granted access by mistake;
+// the table owner, which the repository connects as, is not affected. The
+// policies of multi-tenant tables admit the rows of the current tenant only.
+// There are no statements when no role is set.
+func AccessStatements(d Dialect, t Table, roles Roles) []string {
+	if roles.Empty() {
+		return nil
+	}
+	stmts := CreateRedactedView(d, t)
+
+	all := []string{t.PiiTable(), t.ChainTable(), t.OutboxTable(), t.View(), t.RedactedView()}
+	stmts = append(stmts, "REVOKE ALL ON "+strings.Join(all, ", ")+" FROM PUBLIC")
+	var readers []string
+	if roles.PiiReader != "" {
+		readers = append(readers, roles.PiiReader)
+		stmts = append(stmts, fmt.Sprintf("GRANT SELECT ON %s, %s, %s, %s TO %s", t.PiiTable(), t.ChainTable(), t.View(), t.RedactedView(), roles.PiiReader))
+	}
+	if roles.Public != "" {
+		stmts = append(stmts, fmt.Sprintf("GRANT SELECT ON %s, %s TO %s", t.ChainTable(), t.RedactedView(), roles.Public))
+	}
+
+	piiPolicy := readPolicy(t.PiiTable())
+	stmts = append(stmts,
+		"ALTER TABLE "+t.PiiTable()+" ENABLE ROW LEVEL SECURITY",
+		fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", piiPolicy, t.PiiTable()),
+	)
+	if roles.PiiReader != "" {
+		stmts = append(stmts, fmt.Sprintf("CREATE POLICY %s ON %s FOR SELECT TO %s USING (%s)", piiPolicy, t.PiiTable(), roles.PiiReader, readCondition(t)))
+	}
+
+	chainPolicy := readPolicy(t.ChainTable())
+	if roles.Public != "" {
+		readers = append(readers, roles.Public)
+	}
+	stmts = append(stmts,
+		"ALTER TABLE "+t.ChainTable()+" ENABLE ROW LEVEL SECURITY",
+		fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", chainPolicy, t.ChainTable()),
+		fmt.Sprintf("CREATE POLICY %s ON %s FOR SELECT TO %s USING (%s)", chainPolicy, t.ChainTable(), strings.Join(readers, ", "), readCondition(t)),
+	)
+	return stmts
+}
+
+// DropAccessStatements returns the statements undoing the AccessStatements
+// of t for roles: dropping the redacted view and the read policies, and
+// revoking the grants of roles. Row-level security stays enabled on
+// multi-tenant tables, whose tenant policies need it. There are no
+// statements when no role is set.
+func DropAccessStatements(d Dialect, t Table, roles Roles) []string {
+	if roles.Empty() {
+		return nil
+	}
+	stmts := []string{"DROP VIEW IF EXISTS " + t.RedactedView()}
+	for _, table := range []string{t.PiiTable(), t.ChainTable()} {
+		stmts = append(stmts, fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", readPolicy(table), table))
+		if !t.Tenant {
+			stmts = append(stmts, "ALTER TABLE "+table+" DISABLE ROW LEVEL SECURITY")
+		}
+	}
+	for _, role := range []string{roles.PiiReader, roles.Public} {
+		if role != "" {
+			stmts = append(stmts, fmt.Sprintf("REVOKE ALL ON %s, %s, %s, %s FROM %s", t.PiiTable(), t.ChainTable(), t.OutboxTable(), t.View(), role))
+		}
+	}
+	return stmts
+}
+
+// readPolicy returns the name of the read policy of the roles on table.
+func readPolicy(table string) string {
+	return table + "_read"
+}
+
+// CreateRedactedView returns the statements (re)creating the redacted view
+// of t. Views run with the privileges of their owner, so roles granted the
+// view read it without access to the PII table.
+func CreateRedactedView(d Dialect, t Table) []string {
+	return d.CreateView(t.RedactedView(), RedactedViewQuery(d, t))
+}
@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// generateWithBatchSize generates the method setting the batch size of the
+// SaveBatch and FetchMany of a repository.
+func generateWithBatchSize(g *protogen.GeneratedFile, msg *protogen.Message) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// WithBatchSize returns a copy of the repository whose SaveBatch and FetchMany")
+	g.P("// write and read n records per statement, ", storePackage.Ident("DefaultBatchSize"), " when n < 1.")
+	g.P("func (r *", modelName, "Repo) WithBatchSize(n int) *", modelName, "Repo {")
+	g.P("  c := *r")
+	g.P("  c.batchSize = n")
+	g.P("  if n < 1 {")
+	g.P("    c.batchSize = ", storePackage.Ident("DefaultBatchSize"))
+	g.P("  }")
+	g.P("  return &c")
+	g.P("}")
+	g.P()
+}
+
+// generateWithTx generates the method running a repository in a transaction
+// of type txType the caller owns, stored in the field of the repository.
+func generateWithTx(g *protogen.GeneratedFile, msg *protogen.Message, txType, field string) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// WithTx returns a copy of the repository running in tx, a transaction the caller")
+	g.P("// commits or rolls back, so that the writes of several repositories form one unit")
+	g.P("// of work. Save and SaveBatch run in savepoints of tx, which they leave usable")
+	g.P("// if they fail.")
+	g.P("func (r *", modelName, "Repo) WithTx(tx ", txType, ") *", modelName, "Repo {")
+	g.P("  c := *r")
+	g.P("  c.", field, " = tx")
+	g.P("  return &c")
+	g.P("}")
+	g.P()
+}
+
+// generatePiiLiteral generates the fields of the GORM PII row of model.
+func generatePiiLiteral(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool, indent string) {
+	if tenant {
+		g.P(indent, "TenantID: tenantID,")
+	}
+	for _, field := range msg.Fields {
+		opts := getFieldOptions(field)
+		if opts.PrimaryKey || opts.Pii || opts.QueryIndex {
+			g.P(indent, field.GoName, ": ", piiValue(field, "model."+field.GoName), ",")
+		}
+	}
+}
+
+// generateSaveBatch generates the GORM SaveBatch of a message.
+func generateSaveBatch(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool, opts Options) {
+	modelName := msg.GoIdent.GoName
+	key := "model." + recordKeyField(msg)
+
+	g.P("// SaveBatch saves models as Save does, in one transaction. The rows are written")
+	g.P("// with multi-row inserts and the chain versions looked up per batch of the")
+	g.P("// batch size of the repository.")
+	g.P("func (r *", modelName, "Repo) SaveBatch(ctx context.Context, models []*", modelName, ") error {")
+	g.P("  if len(models) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+	g.P("    for _, batch := range ", storePackage.Ident("Batches"), "(models, r.batchSize) {")
+	if tenant {
+		g.P("      if err := r.saveBatch(tx, tenantID, batch); err != nil {")
+	} else {
+		g.P("      if err := r.saveBatch(tx, batch); err != nil {")
+	}
+	g.P("        return err")
+	g.P("      }")
+	g.P("    }")
+	g.P("    return nil")
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("// saveBatch writes the rows of models with one multi-row insert per table, and")
+	g.P("// per batch of chain rows.")
+	if tenant {
+		g.P("func (r *", modelName, "Repo) saveBatch(tx *gorm.DB, tenantID string, models []*", modelName, ") error {")
+	} else {
+		g.P("func (r *", modelName, "Repo) saveBatch(tx *gorm.DB, models []*", modelName, ") error {")
+	}
+	g.P("  keys := make([]string, len(models))")
+	g.P("  piis := make([]", modelName, "Pii, len(models))")
+	g.P("  for i, model := range models {")
+	g.P("    keys[i] = ", key)
+	g.P("    piis[i] = ", modelName, "Pii{")
+	generatePiiLiteral(g, msg, tenant, "      ")
+	g.P("    }")
+	g.P("  }")
+	g.P("  if err := tx.Create(&piis).Error; err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P()
+	if tenant {
+		g.P("  latest, err := r.latestVersions(tx, tenantID, keys)")
+	} else {
+		g.P("  latest, err := r.latestVersions(tx, keys)")
+	}
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  now := ", timePackage.Ident("Now"), "()")
+	g.P("  var chain []", modelName, "Chain")
+	g.P("  entries := make([]", modelName, "OutboxEntry, 0, len(models))")
+	g.P("  for _, model := range models {")
+	g.P("    var writes []", ledgerPackage.Ident("FieldVersion"))
+	g.P("    appendChain := func(name, value string) {")
+	if tenant {
+		g.P("      row := ", modelName, "Chain{TenantID: tenantID, Key: ", key, ", FieldName: name, Version: latest[", key, "][name] + 1, FieldValue: value, CreatedAt: now}")
+	} else {
+		g.P("      row := ", modelName, "Chain{Key: ", key, ", FieldName: name, Version: latest[", key, "][name] + 1, FieldValue: value, CreatedAt: now}")
+	}
+	g.P("      chain = append(chain, row)")
+	g.P("      writes = append(writes, ", ledgerPackage.Ident("FieldVersion"), "{Field: name, Version: row.Version})")
+	g.P("    }")
+	g.P("    for _, field := range model.ChainFields() {")
+	g.P("      appendChain(field.Name, field.Value)")
+	g.P("    }")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil { return err }")
+	g.P("    appendChain(", merklePackage.Ident("RootField"), ", tree.RootHex())")
+	generateOutboxPayload(g, opts, tenant, "    ")
+	g.P("    entries = append(entries, ", modelName, "OutboxEntry{")
+	if tenant {
+		g.P("      TenantID: tenantID,")
+	}
+	g.P("      Key: ", key, ",")
+	g.P("      Versions: string(versionsJSON),")
+	g.P("      Payload: payload,")
+	g.P("      NextAttemptAt: now,")
+	g.P("    })")
+	g.P("  }")
+	g.P("  if err := tx.CreateInBatches(&chain, r.batchSize).Error; err != nil {")
+	g.P("    return ", storePackage.Ident("VersionConflict"), "(err)")
+	g.P("  }")
+	g.P("  return tx.Create(&entries).Error")
+	g.P("}")
+	g.P()
+}
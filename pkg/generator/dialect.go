@@ -0,0 +1,289 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect renders the SQL constructs that differ between databases.
+type Dialect interface {
+	// Name is the name the dialect is selected by.
+	Name() string
+	// Quote quotes an identifier where the database requires it.
+	Quote(ident string) string
+	// ColumnType returns the column type of t. key is set for columns that are
+	// part of a key or index, or have a default value.
+	ColumnType(t ColumnType, key bool) string
+	// SerialPrimaryKey returns the definition of an auto-incremented primary key column.
+	SerialPrimaryKey(column string) string
+	// CreateView returns the statements (re)creating a view.
+	CreateView(name, query string) []string
+	// CreateIndex returns the statements creating an index if it does not exist.
+	CreateIndex(name, table string, columns []string) []string
+	// DropIndex returns the statement dropping an index of table.
+	DropIndex(name, table string) string
+	// AlterColumnType returns the statement changing the type of a column,
+	// or false if the database cannot alter column types.
+	AlterColumnType(table, column, typ string) (string, bool)
+	// AddForeignKey returns the statement adding a constraint to table, or
+	// false if the database cannot add constraints to existing tables.
+	AddForeignKey(table, constraint string) (string, bool)
+	// DropForeignKey returns the statement dropping the foreign key name of
+	// table, or false if the database cannot drop constraints.
+	DropForeignKey(table, name string) (string, bool)
+	// Placeholder returns the placeholder of the nth (1-based) query parameter.
+	Placeholder(n int) string
+	// Cast returns expr converted to the column type t.
+	Cast(expr string, t ColumnType) string
+	// Hash returns the hex SHA-256 of the text expr, matching ledger.Hash, or
+	// false if the database has no SHA-256 function.
+	Hash(expr string) (string, bool)
+	// Latest returns a query selecting columns from the first row in order,
+	// an ORDER BY list, within each partition of the rows of table matching
+	// where.
+	Latest(table string, partition, columns []string, order, where string) string
+}
+
+// Supported dialects.
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+	DialectMySQL    = "mysql"
+)
+
+// LookupDialect returns the dialect called name, defaulting to Postgres.
+func LookupDialect(name string) (Dialect, error) {
+	switch name {
+	case "", DialectPostgres:
+		return postgresDialect{}, nil
+	case DialectSQLite:
+		return sqliteDialect{}, nil
+	case DialectMySQL:
+		return mysqlDialect{}, nil
+	}
+	return nil, fmt.Errorf("unknown SQL dialect %q (want %q, %q or %q)", name, DialectPostgres, DialectSQLite, DialectMySQL)
+}
+
+// ColumnType is the database independent type of a column.
+type ColumnType string
+
+const (
+	ColumnText    ColumnType = "text"
+	ColumnInteger ColumnType = "integer"
+	ColumnBytes   ColumnType = "bytes"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string              { return DialectPostgres }
+func (postgresDialect) Quote(ident string) string { return ident }
+
+func (postgresDialect) ColumnType(t ColumnType, key bool) string {
+	switch t {
+	case ColumnInteger:
+		return "BIGINT"
+	case ColumnBytes:
+		return "BYTEA"
+	}
+	return "TEXT"
+}
+
+func (postgresDialect) SerialPrimaryKey(column string) string {
+	return column + " BIGSERIAL PRIMARY KEY"
+}
+
+// The view is commented with its version for drift checks.
+func (postgresDialect) CreateView(name, query string) []string {
+	return []string{
+		"CREATE OR REPLACE VIEW " + name + " AS\n" + query,
+		fmt.Sprintf("COMMENT ON VIEW %s IS '%s'", name, ViewVersion(query)),
+	}
+}
+
+func (postgresDialect) CreateIndex(name, table string, columns []string) []string {
+	return []string{fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", "))}
+}
+
+func (postgresDialect) DropIndex(name, table string) string {
+	return "DROP INDEX IF EXISTS " + name
+}
+
+func (postgresDialect) AlterColumnType(table, column, typ string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", table, column, typ, column, typ), true
+}
+
+func (postgresDialect) AddForeignKey(table, constraint string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s ADD %s", table, constraint), true
+}
+
+func (postgresDialect) DropForeignKey(table, name string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", table, name), true
+}
+
+func (postgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (d postgresDialect) Cast(expr string, t ColumnType) string {
+	return fmt.Sprintf("CAST(%s AS %s)", expr, d.ColumnType(t, false))
+}
+
+// sha256 is available from PostgreSQL 11.
+func (postgresDialect) Hash(expr string) (string, bool) {
+	return fmt.Sprintf("encode(sha256(convert_to(%s, 'UTF8')), 'hex')", expr), true
+}
+
+func (postgresDialect) Latest(table string, partition, columns []string, order, where string) string {
+	q := fmt.Sprintf("SELECT DISTINCT ON (%s) %s FROM %s", strings.Join(partition, ", "), strings.Join(columns, ", "), table)
+	if where != "" {
+		q += " WHERE " + where
+	}
+	return q + fmt.Sprintf(" ORDER BY %s, %s", strings.Join(partition, ", "), order)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string              { return DialectSQLite }
+func (sqliteDialect) Quote(ident string) string { return ident }
+
+func (sqliteDialect) ColumnType(t ColumnType, key bool) string {
+	switch t {
+	case ColumnInteger:
+		return "INTEGER"
+	case ColumnBytes:
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+func (sqliteDialect) SerialPrimaryKey(column string) string {
+	return column + " INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+func (sqliteDialect) CreateView(name, query string) []string {
+	return []string{
+		"DROP VIEW IF EXISTS " + name,
+		"CREATE VIEW " + name + " AS\n" + query,
+	}
+}
+
+func (sqliteDialect) CreateIndex(name, table string, columns []string) []string {
+	return []string{fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", "))}
+}
+
+func (sqliteDialect) DropIndex(name, table string) string {
+	return "DROP INDEX IF EXISTS " + name
+}
+
+// SQLite cannot alter a column; the table would have to be rebuilt.
+func (sqliteDialect) AlterColumnType(table, column, typ string) (string, bool) {
+	return "", false
+}
+
+// SQLite only declares foreign keys in CREATE TABLE.
+func (sqliteDialect) AddForeignKey(table, constraint string) (string, bool) {
+	return "", false
+}
+
+func (sqliteDialect) DropForeignKey(table, name string) (string, bool) {
+	return "", false
+}
+
+func (sqliteDialect) Placeholder(n int) string { return "?" }
+
+func (d sqliteDialect) Cast(expr string, t ColumnType) string {
+	return fmt.Sprintf("CAST(%s AS %s)", expr, d.ColumnType(t, false))
+}
+
+// SQLite has no built-in hash functions.
+func (sqliteDialect) Hash(expr string) (string, bool) {
+	return "", false
+}
+
+// SQLite has no DISTINCT ON; the latest row of each partition is picked with
+// a window function.
+func (sqliteDialect) Latest(table string, partition, columns []string, order, where string) string {
+	inner := fmt.Sprintf("SELECT %s, ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS rn FROM %s",
+		strings.Join(columns, ", "), strings.Join(partition, ", "), order, table)
+	if where != "" {
+		inner += " WHERE " + where
+	}
+	return fmt.Sprintf("SELECT %s FROM (%s) latest WHERE rn = 1", strings.Join(columns, ", "), inner)
+}
+
+// mysqlDialect targets MySQL 8 and MariaDB 10.2 or later.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string              { return DialectMySQL }
+func (mysqlDialect) Quote(ident string) string { return "`" + ident + "`" }
+
+// MySQL cannot index or default TEXT columns without a prefix length, so
+// keyed text columns are VARCHARs.
+func (mysqlDialect) ColumnType(t ColumnType, key bool) string {
+	switch t {
+	case ColumnInteger:
+		return "BIGINT"
+	case ColumnBytes:
+		return "LONGBLOB"
+	}
+	if key {
+		return "VARCHAR(255)"
+	}
+	return "TEXT"
+}
+
+func (mysqlDialect) SerialPrimaryKey(column string) string {
+	return column + " BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY"
+}
+
+func (mysqlDialect) CreateView(name, query string) []string {
+	return []string{"CREATE OR REPLACE VIEW " + name + " AS\n" + query}
+}
+
+// MySQL has no CREATE INDEX IF NOT EXISTS, so the index is created by a
+// prepared statement, which is a no-op if information_schema lists an index
+// called name on table already.
+func (mysqlDialect) CreateIndex(name, table string, columns []string) []string {
+	create := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, table, strings.Join(columns, ", "))
+	return []string{
+		fmt.Sprintf("SET @sdm_ddl = IF(EXISTS (SELECT 1 FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = '%s' AND index_name = '%s'), 'DO 0', '%s')", table, name, create),
+		"PREPARE sdm_ddl FROM @sdm_ddl",
+		"EXECUTE sdm_ddl",
+		"DEALLOCATE PREPARE sdm_ddl",
+	}
+}
+
+func (mysqlDialect) DropIndex(name, table string) string {
+	return fmt.Sprintf("DROP INDEX %s ON %s", name, table)
+}
+
+func (mysqlDialect) AlterColumnType(table, column, typ string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, typ), true
+}
+
+func (mysqlDialect) AddForeignKey(table, constraint string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s ADD %s", table, constraint), true
+}
+
+func (mysqlDialect) DropForeignKey(table, name string) (string, bool) {
+	return fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", table, name), true
+}
+
+func (mysqlDialect) Placeholder(n int) string { return "?" }
+
+// MySQL casts to SIGNED, CHAR and BINARY rather than to column types.
+func (mysqlDialect) Cast(expr string, t ColumnType) string {
+	switch t {
+	case ColumnInteger:
+		return "CAST(" + expr + " AS SIGNED)"
+	case ColumnBytes:
+		return "CAST(" + expr + " AS BINARY)"
+	}
+	return "CAST(" + expr + " AS CHAR)"
+}
+
+func (mysqlDialect) Hash(expr string) (string, bool) {
+	return "SHA2(" + expr + ", 256)", true
+}
+
+func (mysqlDialect) Latest(table string, partition, columns []string, order, where string) string {
+	return sqliteDialect{}.Latest(table, partition, columns, order, where)
+}
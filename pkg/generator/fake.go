@@ -0,0 +1,503 @@
+package generator
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const memstorePackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/store/memstore")
+
+// generateStoreInterface generates the <Msg>Store interface of the methods
+// every backend's repository and the fake implement. msg is a message of file.
+func generateStoreInterface(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, t Table) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// ", modelName, "Store is the interface of the ", modelName, " repositories, implemented by")
+	g.P("// ", modelName, "Repo and by the in-memory ", modelName, "Fake for tests.")
+	g.P("type ", modelName, "Store interface {")
+	g.P("  Save(ctx context.Context, model *", modelName, ") error")
+	g.P("  SaveBatch(ctx context.Context, models []*", modelName, ") error")
+	g.P("  Update(ctx context.Context, model *", modelName, ", version int64) error")
+	if t.SoftDelete {
+		g.P("  Delete(ctx context.Context, id string) error")
+		g.P("  Restore(ctx context.Context, id string) error")
+	}
+	g.P("  Fetch(ctx context.Context, id string) (*", modelName, "View, error)")
+	if t.SoftDelete {
+		g.P("  FetchIncludingDeleted(ctx context.Context, id string) (*", modelName, "View, error)")
+	}
+	g.P("  FetchMany(ctx context.Context, ids []string) (map[string]*", modelName, "View, error)")
+	for _, a := range manyAssociations(file, msg) {
+		g.P("  FetchWith", a.Name, "(ctx context.Context, id string) (*", modelName, "View, error)")
+	}
+	g.P("  List(ctx context.Context, opts ", storePackage.Ident("ListOptions"), ") ([]", modelName, "View, string, error)")
+	g.P("  PendingChainWrites(ctx context.Context, key string) ([]", modelName, "Chain, error)")
+	g.P("  MarkChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", ledgerPackage.Ident("Status"), ", txHash string) error")
+	g.P("  ConfirmChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", txHash string) error")
+	g.P("  CompactChain(ctx context.Context, before ", timePackage.Ident("Time"), ", keepVersions int) (int64, error)")
+	g.P("  MerkleRoot(ctx context.Context, key string) (string, error)")
+	g.P("  VerifyFieldProof(ctx context.Context, key string, proof *", merklePackage.Ident("Proof"), ") (bool, error)")
+	for _, field := range hashedFields(msg) {
+		g.P("  Verify", field.GoName, "(ctx context.Context, id string, candidate ", goTypeForField(field), ") (bool, error)")
+	}
+	g.P("  VerifyRecord(ctx context.Context, model *", modelName, ") ([]", ledgerPackage.Ident("Mismatch"), ", error)")
+	g.P("}")
+	g.P()
+}
+
+// notFound returns the import path and the Go source of the error the
+// repositories of backend return for a missing record.
+func notFound(backend string) (importPath, ident string) {
+	switch backend {
+	case BackendSQL:
+		return "database/sql", "sql.ErrNoRows"
+	case BackendPgx:
+		return "github.com/jackc/pgx/v5", "pgx.ErrNoRows"
+	default:
+		return "gorm.io/gorm", "gorm.ErrRecordNotFound"
+	}
+}
+
+// generateFake generates the in-memory fakes of the messages of file. They
+// return the errors of the repositories of opts.Backend.
+func generateFake(gen *protogen.Plugin, file *protogen.File, opts Options) {
+	filename := file.GeneratedFilenamePrefix + "_sdm_fake.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	importPath, errNotFound := notFound(opts.Backend)
+
+	g.P("// Code generated by sdm. DO NOT EDIT.")
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	g.P(`	"context"`)
+	g.P(`	"fmt"`)
+	g.P(`	"sync"`)
+	g.P()
+	g.P(`	"`, importPath, `"`)
+	g.P(")")
+	g.P()
+
+	for _, msg := range file.Messages {
+		generateMessageFake(g, file, msg, NewTable(msg, opts), errNotFound)
+	}
+}
+
+// fakeChildren returns the name of the field of the fake of the referenced
+// message of a, a has-many association, holding the fake of its children.
+func fakeChildren(a association) string {
+	return strings.ToLower(a.Name[:1]) + a.Name[1:]
+}
+
+// generateFakeFetchWith generates the FetchWith<Children> of the fake of msg
+// for each message referencing it, reading the children from the fake set
+// with Use<Children>.
+func generateFakeFetchWith(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, t Table, errNotFound string) {
+	modelName := msg.GoIdent.GoName
+	fake := modelName + "Fake"
+	tenantID := `""`
+	if t.Tenant {
+		tenantID = "tenantID"
+	}
+	for _, a := range manyAssociations(file, msg) {
+		g.P("// FetchWith", a.Name, " returns the view of the record with its ", a.Name, ", ordered by")
+		g.P("// primary key, or ", errNotFound, ".")
+		g.P("func (r *", fake, ") FetchWith", a.Name, "(ctx context.Context, id string) (*", modelName, "View, error) {")
+		generateRequireTenant(g, t.Tenant, "nil")
+		g.P("  r.mu.Lock()")
+		g.P("  view, ok := r.view(", tenantID, ", id)")
+		g.P("  children := r.", fakeChildren(a))
+		g.P("  // Unlock r before locking children, which is r if the message")
+		g.P("  // references itself.")
+		g.P("  r.mu.Unlock()")
+		g.P("  if !ok {")
+		g.P("    return nil, ", errNotFound)
+		g.P("  }")
+		g.P("  if children == nil {")
+		g.P("    return view, nil")
+		g.P("  }")
+		g.P("  children.mu.Lock()")
+		g.P("  defer children.mu.Unlock()")
+		g.P("  for _, key := range children.pii.Keys(", tenantID, ") {")
+		g.P("    child, ok := children.view(", tenantID, ", key)")
+		g.P("    if ok && child.", a.ForeignKey.GoName, " == view.", a.References.GoName, " {")
+		g.P("      view.", a.Name, " = append(view.", a.Name, ", *child)")
+		g.P("    }")
+		g.P("  }")
+		g.P("  return view, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+// piiValue returns the Go source converting expr, the value of field in a
+// model, to the type of its PII and view struct fields.
+func piiValue(field *protogen.Field, expr string) string {
+	switch field.Desc.Kind() {
+	case protoreflect.StringKind, protoreflect.Int64Kind:
+		return expr
+	case protoreflect.Int32Kind:
+		return "int64(" + expr + ")"
+	default:
+		return "fmt.Sprint(" + expr + ")"
+	}
+}
+
+func generateMessageFake(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, t Table, errNotFound string) {
+	modelName := msg.GoIdent.GoName
+	fake := modelName + "Fake"
+	tenantID := `""`
+	if t.Tenant {
+		tenantID = "tenantID"
+	}
+	pk := primaryKeyField(msg)
+	key := `""`
+	if pk != nil {
+		key = "model." + pk.GoName
+	}
+
+	g.P("// ", fake, " is an in-memory ", modelName, "Store for tests. It keeps the PII rows and")
+	g.P("// the chain rows Save writes apart, versions the chain fields per field and")
+	g.P("// derives the view from them as the database does. Chain writes are not queued")
+	g.P("// for the ledger; move them on with MarkChainWrite or ConfirmChainWrite.")
+	g.P("type ", fake, " struct {")
+	g.P("  mu    sync.Mutex")
+	g.P("  pii   ", memstorePackage.Ident("Table"), "[", modelName, "Pii]")
+	g.P("  chain ", memstorePackage.Ident("Chain"))
+	for _, a := range manyAssociations(file, msg) {
+		g.P("  ", fakeChildren(a), " *", a.Message.GoIdent.GoName, "Fake")
+	}
+	g.P("}")
+	g.P()
+	g.P("var _ ", modelName, "Store = (*", fake, ")(nil)")
+	g.P()
+	g.P("// New", fake, " returns an empty ", fake, ".")
+	g.P("func New", fake, "() *", fake, " {")
+	g.P("  return &", fake, "{}")
+	g.P("}")
+	g.P()
+	for _, a := range manyAssociations(file, msg) {
+		child := a.Message.GoIdent.GoName
+		g.P("// Use", a.Name, " makes FetchWith", a.Name, " read the ", a.Name, " of the records from")
+		g.P("// children, as the repositories read them from their table. Without it the")
+		g.P("// records have none.")
+		g.P("func (r *", fake, ") Use", a.Name, "(children *", child, "Fake) *", fake, " {")
+		g.P("  r.mu.Lock()")
+		g.P("  defer r.mu.Unlock()")
+		g.P("  r.", fakeChildren(a), " = children")
+		g.P("  return r")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func (r *", fake, ") Save(ctx context.Context, model *", modelName, ") error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  tree, err := model.MerkleTree()")
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  return r.save(", tenantID, ", model, tree)")
+	g.P("}")
+	g.P()
+
+	g.P("// SaveBatch saves models as Save does. None of them is saved if one fails.")
+	g.P("func (r *", fake, ") SaveBatch(ctx context.Context, models []*", modelName, ") error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  trees := make([]*", merklePackage.Ident("Tree"), ", len(models))")
+	g.P("  for i, model := range models {")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    trees[i] = tree")
+	g.P("  }")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  // Check every key before saving any record, as the transaction of the")
+	g.P("  // repositories would roll back.")
+	g.P("  keys := make(map[string]bool, len(models))")
+	g.P("  for _, model := range models {")
+	g.P("    if _, ok := r.pii.Get(", tenantID, ", ", key, "); ok || keys[", key, "] {")
+	g.P("      return &", memstorePackage.Ident("DuplicateKeyError"), "{TenantID: ", tenantID, ", Key: ", key, "}")
+	g.P("    }")
+	g.P("    keys[", key, "] = true")
+	g.P("  }")
+	g.P("  for i, model := range models {")
+	g.P("    if err := r.save(", tenantID, ", model, trees[i]); err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	generateFakeUpdate(g, msg, t, errNotFound)
+	if t.SoftDelete {
+		generateFakeSoftDelete(g, msg, t, errNotFound)
+	}
+
+	g.P("// save writes the PII row and the chain rows of model.")
+	g.P("func (r *", fake, ") save(tenantID string, model *", modelName, ", tree *", merklePackage.Ident("Tree"), ") error {")
+	g.P("  pii := ", modelName, "Pii{")
+	if t.Tenant {
+		g.P("    TenantID: tenantID,")
+	}
+	for _, field := range msg.Fields {
+		if opts := getFieldOptions(field); opts.PrimaryKey || opts.Pii || opts.QueryIndex {
+			g.P("    ", field.GoName, ": ", piiValue(field, "model."+field.GoName), ",")
+		}
+	}
+	g.P("    RowVersion: 1,")
+	g.P("  }")
+	g.P("  if err := r.pii.Insert(tenantID, ", key, ", pii); err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  fields := append(model.ChainFields(), ", ledgerPackage.Ident("Field"), "{Name: ", merklePackage.Ident("RootField"), ", Value: tree.RootHex()})")
+	g.P("  r.chain.Append(tenantID, ", key, ", fields, ", timePackage.Ident("Now"), "())")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	g.P("// Fetch returns the view of the record, or ", errNotFound, ".")
+	g.P("func (r *", fake, ") Fetch(ctx context.Context, id string) (*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  view, ok := r.view(", tenantID, ", id)")
+	g.P("  if !ok {")
+	g.P("    return nil, ", errNotFound)
+	g.P("  }")
+	g.P("  return view, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// FetchMany returns the views of the records of ids by id. Missing records are")
+	g.P("// left out.")
+	g.P("func (r *", fake, ") FetchMany(ctx context.Context, ids []string) (map[string]*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  views := make(map[string]*", modelName, "View, len(ids))")
+	g.P("  for _, id := range ids {")
+	g.P("    if view, ok := r.view(", tenantID, ", id); ok {")
+	g.P("      views[id] = view")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return views, nil")
+	g.P("}")
+	g.P()
+
+	generateFakeFetchWith(g, file, msg, t, errNotFound)
+
+	g.P(listDoc)
+	g.P("func (r *", fake, ") List(ctx context.Context, opts ", storePackage.Ident("ListOptions"), ") ([]", modelName, "View, string, error) {")
+	generateRequireTenant(g, t.Tenant, `nil, ""`)
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  keys, next, err := r.pii.List(", tenantID, ", opts, func(key string) (bool, error) {")
+	g.P("    view, ok := r.view(", tenantID, ", key)")
+	g.P("    if !ok {")
+	g.P("      return false, nil")
+	g.P("    }")
+	g.P("    return r.match(view, opts.Filters)")
+	g.P("  })")
+	g.P("  if err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	g.P("  views := make([]", modelName, "View, 0, len(keys))")
+	g.P("  for _, key := range keys {")
+	g.P("    view, _ := r.view(", tenantID, ", key)")
+	g.P("    views = append(views, *view)")
+	g.P("  }")
+	g.P("  return views, next, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// match reports whether view matches all of filters.")
+	g.P("func (r *", fake, ") match(view *", modelName, "View, filters []", storePackage.Ident("Filter"), ") (bool, error) {")
+	if len(filterFields(msg)) == 0 {
+		g.P("  if len(filters) > 0 {")
+		g.P("    return false, fmt.Errorf(\"cannot filter by %q\", filters[0].Column)")
+		g.P("  }")
+	} else {
+		g.P("  for _, f := range filters {")
+		g.P("    var value any")
+		g.P("    switch f.Column {")
+		for _, field := range filterFields(msg) {
+			g.P("    case \"", field.Desc.Name(), "\":")
+			g.P("      value = view.", field.GoName)
+		}
+		g.P("    default:")
+		g.P("      return false, fmt.Errorf(\"cannot filter by %q\", f.Column)")
+		g.P("    }")
+		g.P("    if ok, err := ", storePackage.Ident("Match"), "(f, value); err != nil || !ok {")
+		g.P("      return false, err")
+		g.P("    }")
+		g.P("  }")
+	}
+	g.P("  return true, nil")
+	g.P("}")
+	g.P()
+
+	if t.SoftDelete {
+		g.P("// view derives the view of the record, leaving out deleted records.")
+		g.P("func (r *", fake, ") view(tenantID, id string) (*", modelName, "View, bool) {")
+		g.P("  view, ok := r.viewIncludingDeleted(tenantID, id)")
+		g.P("  if !ok || view.DeletedAt != nil {")
+		g.P("    return nil, false")
+		g.P("  }")
+		g.P("  return view, true")
+		g.P("}")
+		g.P()
+		g.P("// viewIncludingDeleted derives the view of the record from its PII row and")
+		g.P("// chain rows.")
+		g.P("func (r *", fake, ") viewIncludingDeleted(tenantID, id string) (*", modelName, "View, bool) {")
+	} else {
+		g.P("// view derives the view of the record from its PII row and chain rows.")
+		g.P("func (r *", fake, ") view(tenantID, id string) (*", modelName, "View, bool) {")
+	}
+	g.P("  pii, ok := r.pii.Get(tenantID, id)")
+	g.P("  if !ok {")
+	g.P("    return nil, false")
+	g.P("  }")
+	for _, f := range t.Fields {
+		if !f.InPii() || f.Hashed {
+			// Only the view of chain and hashed fields reads chain values.
+			g.P("  latest := r.chain.Latest(tenantID, id)")
+			break
+		}
+	}
+	g.P("  view := ", modelName, "View{")
+	if t.Tenant {
+		g.P("    TenantID: pii.TenantID,")
+	}
+	for _, field := range msg.Fields {
+		opts := getFieldOptions(field)
+		name := string(field.Desc.Name())
+		switch {
+		case opts.PrimaryKey || opts.Pii || opts.QueryIndex:
+			g.P("    ", field.GoName, ": pii.", field.GoName, ",")
+		case goTypeForField(field) == "int64":
+			g.P("    ", field.GoName, ": ", memstorePackage.Ident("Int64"), "(latest[\"", name, "\"].FieldValue),")
+		default:
+			g.P("    ", field.GoName, ": latest[\"", name, "\"].FieldValue,")
+		}
+		if opts.Hashed {
+			g.P("    Hashed", field.GoName, ": latest[\"hashed_", name, "\"].FieldValue,")
+		}
+	}
+	g.P("    RowVersion: pii.RowVersion,")
+	if t.SoftDelete {
+		g.P("    DeletedAt: pii.DeletedAt,")
+	}
+	g.P("  }")
+	g.P("  view.ChainStatus, view.TxHash = r.chain.Status(tenantID, id)")
+	g.P("  return &view, true")
+	g.P("}")
+	g.P()
+
+	g.P("// PendingChainWrites returns the chain rows of the record that are not confirmed on the ledger yet.")
+	g.P("func (r *", fake, ") PendingChainWrites(ctx context.Context, key string) ([]", modelName, "Chain, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  var chain []", modelName, "Chain")
+	g.P("  for _, row := range r.chain.Rows(", tenantID, ", key) {")
+	g.P("    if row.Status == ", ledgerPackage.Ident("StatusConfirmed"), " {")
+	g.P("      continue")
+	g.P("    }")
+	g.P("    chain = append(chain, ", modelName, "Chain{")
+	if t.Tenant {
+		g.P("      TenantID:   row.TenantID,")
+	}
+	g.P("      Key:        row.Key,")
+	g.P("      FieldName:  row.FieldName,")
+	g.P("      Version:    row.Version,")
+	g.P("      TxHash:     row.TxHash,")
+	g.P("      FieldValue: row.FieldValue,")
+	g.P("      Status:     row.Status,")
+	g.P("      CreatedAt:  row.CreatedAt,")
+	g.P("    })")
+	g.P("  }")
+	g.P("  return chain, nil")
+	g.P("}")
+	g.P()
+
+	generateMarkChainWriteDoc(g)
+	g.P("func (r *", fake, ") MarkChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", ledgerPackage.Ident("Status"), ", txHash string) error {")
+	g.P("  if !status.Valid() {")
+	g.P("    return fmt.Errorf(\"invalid chain status %q\", status)")
+	g.P("  }")
+	g.P("  if len(writes) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  if r.chain.Mark(", tenantID, ", key, writes, status, txHash) == 0 {")
+	g.P("    return fmt.Errorf(\"no chain rows for key %q at %v\", key, writes)")
+	g.P("  }")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	generateConfirmChainWrite(g, msg, fake)
+
+	g.P("// CompactChain deletes the chain rows the CompactChain of ", modelName, "Repo deletes.")
+	g.P("func (r *", fake, ") CompactChain(ctx context.Context, before ", timePackage.Ident("Time"), ", keepVersions int) (int64, error) {")
+	g.P("  if keepVersions < 1 {")
+	g.P("    return 0, fmt.Errorf(\"compacting chain: keepVersions must be at least 1, got %d\", keepVersions)")
+	g.P("  }")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  return r.chain.Compact(before, keepVersions), nil")
+	g.P("}")
+	g.P()
+
+	g.P("// MerkleRoot returns the latest Merkle root committed for the record.")
+	g.P("func (r *", fake, ") MerkleRoot(ctx context.Context, key string) (string, error) {")
+	generateRequireTenant(g, t.Tenant, `""`)
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  row, ok := r.chain.Latest(", tenantID, ", key)[", merklePackage.Ident("RootField"), "]")
+	g.P("  if !ok {")
+	g.P("    return \"\", ", errNotFound)
+	g.P("  }")
+	g.P("  return row.FieldValue, nil")
+	g.P("}")
+	g.P()
+
+	generateVerifyFieldProof(g, msg, fake)
+
+	g.P("// publishedHashes returns the latest published hash of every hashed field of")
+	g.P("// the record, keyed by chain field name.")
+	g.P("func (r *", fake, ") publishedHashes(ctx context.Context, key string) (map[string]string, error) {")
+	if len(hashedFields(msg)) == 0 {
+		g.P("  return map[string]string{}, nil")
+		g.P("}")
+		g.P()
+		generateHashVerifyMethods(g, msg, fake)
+		return
+	}
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  published := make(map[string]string)")
+	g.P("  for name, row := range r.chain.Latest(", tenantID, ", key) {")
+	g.P("    switch name {")
+	for _, field := range hashedFields(msg) {
+		g.P("    case \"hashed_", field.Desc.Name(), "\":")
+		g.P("      published[name] = row.FieldValue")
+	}
+	g.P("    }")
+	g.P("  }")
+	g.P("  return published, nil")
+	g.P("}")
+	g.P()
+
+	generateHashVerifyMethods(g, msg, fake)
+}
@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// fileStoreName returns the name of the Store of the repositories of file:
+// Store, or <File>FileStore when another generated file of its Go package
+// would declare a Store too or a message of file takes the name.
+func fileStoreName(gen *protogen.Plugin, file *protogen.File) string {
+	unique := true
+	for _, f := range gen.Files {
+		if f != file && f.Generate && len(f.Messages) > 0 && f.GoImportPath == file.GoImportPath {
+			unique = false
+		}
+	}
+	for _, msg := range file.Messages {
+		if name := msg.GoIdent.GoName; name == "Store" || name == "NewStore" {
+			unique = false
+		}
+	}
+	if unique {
+		return "Store"
+	}
+	base := strings.NewReplacer("-", "_", ".", "_").Replace(path.Base(file.GeneratedFilenamePrefix))
+	return goFieldName(base) + "FileStore"
+}
+
+// generateFileStore generates the Store of the repositories of the messages
+// of file, whose WithinTx runs them in one transaction. dbType is the type of
+// the database of the repositories of the backend.
+func generateFileStore(g *protogen.GeneratedFile, gen *protogen.Plugin, file *protogen.File, backend, dbType string) {
+	name := fileStoreName(gen, file)
+
+	g.P("// ", name, " holds the repositories of the messages of ", file.Desc.Path(), ". Its WithinTx")
+	g.P("// saves records of several of them in one transaction.")
+	g.P("type ", name, " struct {")
+	g.P("  db ", dbType)
+	if backend == BackendSQL {
+		g.P("  tx *sql.Tx")
+	}
+	for _, msg := range file.Messages {
+		g.P("  ", msg.GoIdent.GoName, " *", msg.GoIdent.GoName, "Repo")
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// New", name, " returns the ", name, " of the repositories on db.")
+	g.P("func New", name, "(db ", dbType, ") *", name, " {")
+	g.P("  return &", name, "{")
+	g.P("    db: db,")
+	for _, msg := range file.Messages {
+		g.P("    ", msg.GoIdent.GoName, ": New", msg.GoIdent.GoName, "Repo(db),")
+	}
+	g.P("  }")
+	g.P("}")
+	g.P()
+
+	g.P("// WithinTx runs fn with a copy of the ", name, " whose repositories run in one")
+	g.P("// transaction, committed if fn returns nil and rolled back otherwise. Within")
+	g.P("// the transaction of another WithinTx, it runs in a savepoint of it.")
+	g.P("func (s *", name, ") WithinTx(ctx context.Context, fn func(*", name, ") error) error {")
+	switch backend {
+	case BackendSQL:
+		g.P("  return ", storePackage.Ident("InTx"), "(ctx, s.db, s.tx, func(tx *sql.Tx) error {")
+		g.P("    return fn(&", name, "{")
+		g.P("      db: s.db,")
+		g.P("      tx: tx,")
+	case BackendPgx:
+		g.P("  return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {")
+		g.P("    return fn(&", name, "{")
+		g.P("      db: tx,")
+	default:
+		g.P("  return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+		g.P("    return fn(&", name, "{")
+		g.P("      db: tx,")
+	}
+	for _, msg := range file.Messages {
+		g.P("      ", msg.GoIdent.GoName, ": s.", msg.GoIdent.GoName, ".WithTx(tx),")
+	}
+	g.P("    })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
@@ -11,21 +11,57 @@ import (
 	sdm "github.com/jinuthankachan/sdm/sdmprotos" // Import the generated code for annotations
 )
 
+const (
+	ledgerPackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/ledger")
+	storePackage  = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/store")
+)
+
 // GenerateFile generates the SDM artifacts for a single proto file.
-func GenerateFile(gen *protogen.Plugin, file *protogen.File) {
+func GenerateFile(gen *protogen.Plugin, file *protogen.File, opts Options) {
 	if len(file.Messages) == 0 {
 		return
 	}
 
+	dialect, err := LookupDialect(opts.Dialect)
+	if err != nil {
+		gen.Error(err)
+		return
+	}
+	if err := ValidateReferences(file); err != nil {
+		gen.Error(err)
+		return
+	}
+	if err := ValidateRowVersion(file); err != nil {
+		gen.Error(err)
+		return
+	}
+	if err := ValidateSoftDelete(file, opts); err != nil {
+		gen.Error(err)
+		return
+	}
+	if err := ValidateChainFields(file); err != nil {
+		gen.Error(err)
+		return
+	}
+
 	// generate Go models
-	generateModels(gen, file)
+	generateModels(gen, file, opts)
 	// generate SQL schema
-	generateSQL(gen, file)
-	// generate GORM repository
-	generateRepo(gen, file)
+	generateSQL(gen, file, dialect, opts)
+	// generate repository
+	switch opts.Backend {
+	case BackendSQL:
+		generateSQLRepo(gen, file, dialect, opts)
+	case BackendPgx:
+		generatePgxRepo(gen, file, dialect, opts)
+	default:
+		generateRepo(gen, file, dialect, opts)
+	}
+	// generate in-memory fakes
+	generateFake(gen, file, opts)
 }
 
-func generateModels(gen *protogen.Plugin, file *protogen.File) {
+func generateModels(gen *protogen.Plugin, file *protogen.File, opts Options) {
 	filename := file.GeneratedFilenamePrefix + "_sdm_model.go"
 	g := gen.NewGeneratedFile(filename, file.GoImportPath)
 
@@ -39,15 +75,25 @@ func generateModels(gen *protogen.Plugin, file *protogen.File) {
 	g.P()
 
 	for _, msg := range file.Messages {
-		generateMessageModels(g, msg)
+		t := NewTable(msg, opts)
+		tenant := t.Tenant
+		generateMessageModels(g, file, msg, t)
+		generateOutboxModel(g, msg, tenant)
+		generateChainPayload(g, msg, tenant)
+		generateMerkleMethods(g, msg, tenant)
+		generateFilterFields(g, msg)
 	}
 }
 
-func generateMessageModels(g *protogen.GeneratedFile, msg *protogen.Message) {
+func generateMessageModels(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, t Table) {
 	modelName := msg.GoIdent.GoName
+	tenant := t.Tenant
 
 	// PII Table Structure
 	g.P("type ", modelName, "Pii struct {")
+	if tenant {
+		g.P("TenantID string `gorm:\"column:tenant_id;primaryKey\"`")
+	}
 	for _, field := range msg.Fields {
 		opts := getFieldOptions(field)
 		if opts.PrimaryKey || opts.Pii || opts.QueryIndex {
@@ -55,17 +101,26 @@ func generateMessageModels(g *protogen.GeneratedFile, msg *protogen.Message) {
 			g.P(field.GoName, " ", goType, " `gorm:\"column:", field.Desc.Name(), ";primaryKey\"`") // Simplified: all are PK for now in definition if needed, but really mostly ID is PK
 		}
 	}
+	g.P("RowVersion int64 `gorm:\"column:row_version;default:1\"`")
+	if t.SoftDelete {
+		g.P("DeletedAt *time.Time `gorm:\"column:deleted_at\"`")
+	}
+	generateAssociations(g, file, msg, "Pii", tenant)
 	g.P("}")
 	g.P()
 
 	// Chain Table Structure (Generic per message type, though usually one global table is better,
 	// requirement implies per object? 'chain_invoices' table. So yes, specific table per object type).
 	g.P("type ", modelName, "Chain struct {")
+	if tenant {
+		g.P("TenantID string `gorm:\"primaryKey;column:tenant_id\"`")
+	}
 	g.P("Key string `gorm:\"primaryKey;column:key\"`")
 	g.P("FieldName string `gorm:\"primaryKey;column:field_name\"`")
-	g.P("Version int64 `gorm:\"primaryKey;column:version;autoIncrement\"`")
+	g.P("Version int64 `gorm:\"primaryKey;column:version;autoIncrement:false\"`")
 	g.P("TxHash string `gorm:\"column:tx_hash\"`")
 	g.P("FieldValue string `gorm:\"column:field_value\"`")
+	g.P("Status ", ledgerPackage.Ident("Status"), " `gorm:\"column:status;default:pending\"`")
 	g.P("CreatedAt time.Time `gorm:\"column:created_at\"`")
 	g.P("}")
 	g.P()
@@ -73,6 +128,9 @@ func generateMessageModels(g *protogen.GeneratedFile, msg *protogen.Message) {
 	// View Structure (Combined)
 	// This structure should match the "View" description in requirements.
 	g.P("type ", modelName, "View struct {")
+	if tenant {
+		g.P("TenantID string `gorm:\"column:tenant_id\"`")
+	}
 	for _, field := range msg.Fields {
 		goType := goTypeForField(field)
 		g.P(field.GoName, " ", goType, " `gorm:\"column:", field.Desc.Name(), "\"`")
@@ -83,7 +141,16 @@ func generateMessageModels(g *protogen.GeneratedFile, msg *protogen.Message) {
 			g.P("Hashed", field.GoName, " string `gorm:\"column:hashed_", field.Desc.Name(), "\"`")
 		}
 	}
+	// Row version of the PII row, which Update checks
+	g.P("RowVersion int64 `gorm:\"column:row_version\"`")
+	if t.SoftDelete {
+		// Time the record was deleted at, set for FetchIncludingDeleted only
+		g.P("DeletedAt *time.Time `gorm:\"column:deleted_at\"`")
+	}
+	// Latest confirmed ledger transaction and the aggregated ledger status of the record
 	g.P("TxHash string `gorm:\"column:tx_hash\"`")
+	g.P("ChainStatus ", ledgerPackage.Ident("Status"), " `gorm:\"column:chain_status\"`")
+	generateAssociations(g, file, msg, "View", tenant)
 	g.P("}")
 	g.P()
 
@@ -94,89 +161,7 @@ func generateMessageModels(g *protogen.GeneratedFile, msg *protogen.Message) {
 	g.P()
 }
 
-func generateSQL(gen *protogen.Plugin, file *protogen.File) {
-	filename := file.GeneratedFilenamePrefix + "_sdm_schema.sql"
-	g := gen.NewGeneratedFile(filename, "")
-
-	for _, msg := range file.Messages {
-		modelName := strings.ToLower(msg.GoIdent.GoName)
-
-		// PII Table
-		g.P("CREATE TABLE IF NOT EXISTS pii_", modelName, "s (")
-		pkFields := []string{}
-		for _, field := range msg.Fields {
-			opts := getFieldOptions(field)
-			if opts.PrimaryKey || opts.Pii || opts.QueryIndex {
-				sqlType := sqlTypeForField(field)
-				line := fmt.Sprintf("  %s %s,", field.Desc.Name(), sqlType)
-				if opts.PrimaryKey {
-					pkFields = append(pkFields, string(field.Desc.Name()))
-				}
-				g.P(line)
-			}
-		}
-		if len(pkFields) > 0 {
-			g.P("  PRIMARY KEY (", strings.Join(pkFields, ", "), ")")
-		}
-		g.P(");")
-		g.P()
-
-		// Chain Table
-		g.P("CREATE TABLE IF NOT EXISTS chain_", modelName, "s (")
-		g.P("  key TEXT NOT NULL,")
-		g.P("  field_name TEXT NOT NULL,")
-		g.P("  version BIGSERIAL,")
-		g.P("  tx_hash TEXT,")
-		g.P("  field_value TEXT,")
-		g.P("  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,")
-		g.P("  PRIMARY KEY (key, field_name, version)")
-		g.P(");")
-		g.P()
-
-		// View
-		// Need to join PII table with latest Chain entries for each hashed field
-		g.P("CREATE OR REPLACE VIEW ", modelName, "s AS")
-
-		selects := []string{}
-		joins := []string{}
-
-		// PII table alias p
-		for _, field := range msg.Fields {
-			opts := getFieldOptions(field)
-			colName := string(field.Desc.Name())
-
-			if opts.PrimaryKey || opts.Pii || opts.QueryIndex {
-				// Available in PII table
-				selects = append(selects, fmt.Sprintf("p.%s", colName))
-			} else {
-				// It's a chain field
-				// We need a join for this field
-				alias := "c_" + colName
-				joins = append(joins, fmt.Sprintf("LEFT JOIN (SELECT DISTINCT ON (key, field_name) field_value, key FROM chain_%ss WHERE field_name='%s' ORDER BY key, field_name, version DESC) %s ON p.id = %s.key", modelName, colName, alias, alias))
-				selects = append(selects, fmt.Sprintf("%s.field_value AS %s", alias, colName))
-			}
-
-			if opts.Hashed {
-				// Also need the hashed value
-				hashedName := "hashed_" + colName
-				alias := "c_" + hashedName
-				joins = append(joins, fmt.Sprintf("LEFT JOIN (SELECT DISTINCT ON (key, field_name) field_value, key FROM chain_%ss WHERE field_name='%s' ORDER BY key, field_name, version DESC) %s ON p.id = %s.key", modelName, hashedName, alias, alias))
-				selects = append(selects, fmt.Sprintf("%s.field_value AS %s", alias, hashedName))
-			}
-		}
-
-		g.P("  SELECT")
-		g.P("    ", strings.Join(selects, ",\n    "))
-		g.P("  FROM pii_", modelName, "s p")
-		for _, join := range joins {
-			g.P("  ", join)
-		}
-		g.P(";")
-		g.P()
-	}
-}
-
-func generateRepo(gen *protogen.Plugin, file *protogen.File) {
+func generateRepo(gen *protogen.Plugin, file *protogen.File, d Dialect, opts Options) {
 	filename := file.GeneratedFilenamePrefix + "_sdm_repo.go"
 	g := gen.NewGeneratedFile(filename, file.GoImportPath)
 
@@ -184,8 +169,6 @@ func generateRepo(gen *protogen.Plugin, file *protogen.File) {
 	g.P()
 	g.P("import (")
 	g.P(`	"context"`)
-	g.P(`	"crypto/sha256"`)
-	g.P(`	"encoding/hex"`)
 	g.P(`	"fmt"`)
 	g.P(`	"gorm.io/gorm"`)
 	g.P(")")
@@ -193,79 +176,306 @@ func generateRepo(gen *protogen.Plugin, file *protogen.File) {
 
 	for _, msg := range file.Messages {
 		modelName := msg.GoIdent.GoName
-		// Repo Interface
+		t := NewTable(msg, opts)
+		tenant := t.Tenant
+		generateStoreInterface(g, file, msg, t)
+
 		g.P("type ", modelName, "Repo struct {")
 		g.P("  db *gorm.DB")
+		g.P("  batchSize int")
 		g.P("}")
 		g.P()
 
 		g.P("func New", modelName, "Repo(db *gorm.DB) *", modelName, "Repo {")
-		g.P("  return &", modelName, "Repo{db: db}")
+		g.P("  return &", modelName, "Repo{db: db, batchSize: ", storePackage.Ident("DefaultBatchSize"), "}")
 		g.P("}")
 		g.P()
+		generateWithBatchSize(g, msg)
+		generateWithTx(g, msg, "*gorm.DB", "db")
+		g.P("var _ ", modelName, "Store = (*", modelName, "Repo)(nil)")
+		g.P()
 
 		// Save
 		g.P("func (r *", modelName, "Repo) Save(ctx context.Context, model *", modelName, ") error {")
-		g.P("  return r.db.Transaction(func(tx *gorm.DB) error {")
+		generateRequireTenant(g, tenant, "")
+		g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+		g.P("  return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
 
 		// Prepare PII Struct
 		g.P("    pii := ", modelName, "Pii{")
-		pkField := ""
-		for _, field := range msg.Fields {
-			opts := getFieldOptions(field)
-			if opts.PrimaryKey {
-				pkField = field.GoName
-			}
-			if opts.Pii || opts.PrimaryKey {
-				g.P("      ", field.GoName, ": model.", field.GoName, ",")
-			}
-		}
+		generatePiiLiteral(g, msg, tenant, "      ")
 		g.P("    }")
 		g.P("    if err := tx.Create(&pii).Error; err != nil { return err }")
 		g.P()
 
-		// Prepare Chain Entries
-		g.P("    // Save Chain Fields")
-		for _, field := range msg.Fields {
-			opts := getFieldOptions(field)
-			// Non-PII fields go to chain
-			if !opts.Pii {
-				g.P("    if err := tx.Create(&", modelName, "Chain{")
-				g.P("      Key: model.", pkField, ",")
-				g.P("      FieldName: \"", field.Desc.Name(), "\",")
-				g.P("      FieldValue: fmt.Sprintf(\"%v\", model.", field.GoName, "),") // Naive string conversion
-				g.P("    }).Error; err != nil { return err }")
-			}
-
-			// Hashed fields
-			if opts.Hashed {
-				g.P("    // Hash ", field.GoName)
-				g.P("    h_", field.GoName, " := sha256.Sum256([]byte(fmt.Sprintf(\"%v\", model.", field.GoName, ")))")
-				g.P("    hashed_", field.GoName, " := hex.EncodeToString(h_", field.GoName, "[:])")
-				g.P("    if err := tx.Create(&", modelName, "Chain{")
-				g.P("      Key: model.", pkField, ",")
-				g.P("      FieldName: \"hashed_", field.Desc.Name(), "\",")
-				g.P("      FieldValue: hashed_", field.GoName, ",")
-				g.P("    }).Error; err != nil { return err }")
-			}
+		generateGormSaveChain(g, msg, tenant, opts)
+		g.P("  })")
+		g.P("  })")
+		g.P("}")
+		g.P()
+
+		generateSaveBatch(g, msg, tenant, opts)
+		generateGormUpdate(g, msg, t, opts)
+		if t.SoftDelete {
+			generateGormSoftDelete(g, msg, d, t)
 		}
 
-		g.P("    return nil")
-		g.P("  })")
+		// Latest chain versions
+		g.P("// latestVersions returns the latest chain version of every field of the records")
+		g.P("// of keys, by key.")
+		if tenant {
+			g.P("func (r *", modelName, "Repo) latestVersions(tx *gorm.DB, tenantID string, keys []string) (map[string]map[string]int64, error) {")
+		} else {
+			g.P("func (r *", modelName, "Repo) latestVersions(tx *gorm.DB, keys []string) (map[string]map[string]int64, error) {")
+		}
+		g.P("  var rows []struct {")
+		g.P("    Key       string")
+		g.P("    FieldName string")
+		g.P("    Version   int64")
+		g.P("  }")
+		g.P("  err := tx.Model(&", modelName, "Chain{}).")
+		g.P("    Select(", fmt.Sprintf("%q", d.Quote("key")+", field_name, MAX(version) AS version"), ").")
+		g.P("    Where(", scoped(tenant, `"key": keys`), ").")
+		g.P("    Group(", fmt.Sprintf("%q", d.Quote("key")+", field_name"), ").Scan(&rows).Error")
+		g.P("  if err != nil {")
+		g.P("    return nil, err")
+		g.P("  }")
+		g.P("  latest := make(map[string]map[string]int64, len(keys))")
+		g.P("  for _, row := range rows {")
+		g.P("    if latest[row.Key] == nil {")
+		g.P("      latest[row.Key] = make(map[string]int64)")
+		g.P("    }")
+		g.P("    latest[row.Key][row.FieldName] = row.Version")
+		g.P("  }")
+		g.P("  return latest, nil")
 		g.P("}")
 		g.P()
 
 		// Fetch
 		g.P("func (r *", modelName, "Repo) Fetch(ctx context.Context, id string) (*", modelName, "View, error) {")
+		generateRequireTenant(g, tenant, "nil")
 		g.P("  var view ", modelName, "View")
 		g.P("  // GORM might not support querying Views directly with First if it doesn't know it's a table. ")
 		g.P("  // But we defined TableName() to return the view name, so it should work.")
-		g.P("  if err := r.db.WithContext(ctx).Where(\"id = ?\", id).First(&view).Error; err != nil {") // Asuming 'id' column exists in view
+		g.P("  if err := r.db.WithContext(ctx).Where(", scoped(tenant, fmt.Sprintf("%q: id", recordKeyName(msg))), ").First(&view).Error; err != nil {")
 		g.P("    return nil, err")
 		g.P("  }")
 		g.P("  return &view, nil")
 		g.P("}")
+		g.P()
+
+		g.P("// FetchMany returns the views of the records of ids by id, reading them the")
+		g.P("// batch size of the repository at a time. Missing records are left out.")
+		g.P("func (r *", modelName, "Repo) FetchMany(ctx context.Context, ids []string) (map[string]*", modelName, "View, error) {")
+		generateRequireTenant(g, tenant, "nil")
+		g.P("  views := make(map[string]*", modelName, "View, len(ids))")
+		g.P("  for _, batch := range ", storePackage.Ident("Batches"), "(ids, r.batchSize) {")
+		g.P("    var rows []", modelName, "View")
+		g.P("    if err := r.db.WithContext(ctx).Where(", scoped(tenant, fmt.Sprintf("%q: batch", recordKeyName(msg))), ").Find(&rows).Error; err != nil {")
+		g.P("      return nil, err")
+		g.P("    }")
+		g.P("    for i := range rows {")
+		g.P("      views[rows[i].", recordKeyField(msg), "] = &rows[i]")
+		g.P("    }")
+		g.P("  }")
+		g.P("  return views, nil")
+		g.P("}")
+		g.P()
+
+		generateGormFetchWith(g, file, msg, tenant)
+		generateGormList(g, msg, d, tenant)
+		generateChainWriteMethods(g, msg, tenant)
+		generateCompactChain(g, msg, CompactChainQuery(d, t))
+		generateMerkleRepoMethods(g, msg, tenant)
+		generateVerifyMethods(g, msg, tenant)
+		generateOutboxStore(g, msg, tenant)
+	}
+	generateFileStore(g, gen, file, opts.Backend, "*gorm.DB")
+}
+
+// generateGormSaveChain generates the end of the transaction of the GORM Save
+// and Update of msg, run on tx after the PII row of model is written: the
+// chain rows of its chain fields and Merkle root, and their outbox entry.
+func generateGormSaveChain(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool, opts Options) {
+	modelName := msg.GoIdent.GoName
+	pkField := ""
+	if pk := primaryKeyField(msg); pk != nil {
+		pkField = pk.GoName
+	}
+
+	// Prepare Chain Entries
+	g.P("    // Save Chain Fields")
+	g.P("    // Versions count per (key, field_name). The PII row written above holds")
+	g.P("    // off concurrent writers of the record; a version taken anyway is retried.")
+	if tenant {
+		g.P("    latest, err := r.latestVersions(tx, tenantID, []string{model.", pkField, "})")
+	} else {
+		g.P("    latest, err := r.latestVersions(tx, []string{model.", pkField, "})")
+	}
+	g.P("    if err != nil { return err }")
+	g.P("    var writes []", ledgerPackage.Ident("FieldVersion"))
+	g.P("    appendChain := func(name, value string) error {")
+	if tenant {
+		g.P("      row := ", modelName, "Chain{TenantID: tenantID, Key: model.", pkField, ", FieldName: name, Version: latest[model.", pkField, "][name] + 1, FieldValue: value}")
+	} else {
+		g.P("      row := ", modelName, "Chain{Key: model.", pkField, ", FieldName: name, Version: latest[model.", pkField, "][name] + 1, FieldValue: value}")
+	}
+	g.P("      if err := tx.Create(&row).Error; err != nil { return ", storePackage.Ident("VersionConflict"), "(err) }")
+	g.P("      writes = append(writes, ", ledgerPackage.Ident("FieldVersion"), "{Field: name, Version: row.Version})")
+	g.P("      return nil")
+	g.P("    }")
+	g.P("    for _, field := range model.ChainFields() {")
+	g.P("      if err := appendChain(field.Name, field.Value); err != nil { return err }")
+	g.P("    }")
+	g.P()
+
+	// Commit to the chain fields of this version with a Merkle root
+	g.P("    // Save Merkle Root of the Chain Fields")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil { return err }")
+	g.P("    if err := appendChain(", merklePackage.Ident("RootField"), ", tree.RootHex()); err != nil { return err }")
+	g.P()
+
+	// Queue the chain fields for the ledger in the same transaction
+	g.P("    // Queue Chain Fields for the ledger")
+	generateOutboxPayload(g, opts, tenant, "    ")
+	g.P("    if err := tx.Create(&", modelName, "OutboxEntry{")
+	if tenant {
+		g.P("      TenantID: tenantID,")
+	}
+	g.P("      Key: model.", pkField, ",")
+	g.P("      Versions: string(versionsJSON),")
+	g.P("      Payload: payload,")
+	g.P("      NextAttemptAt: ", timePackage.Ident("Now"), "(),")
+	g.P("    }).Error; err != nil { return err }")
+	g.P()
+	g.P("    return nil")
+}
+
+// generateChainWriteMethods generates the methods used by a ledger submitter
+// to follow chain rows from pending to confirmed.
+func generateChainWriteMethods(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	status := ledgerPackage.Ident("Status")
+
+	g.P("// PendingChainWrites returns the chain rows of the record that are not confirmed on the ledger yet.")
+	g.P("func (r *", modelName, "Repo) PendingChainWrites(ctx context.Context, key string) ([]", modelName, "Chain, error) {")
+	generateRequireTenant(g, tenant, "nil")
+	g.P("  var rows []", modelName, "Chain")
+	g.P("  err := r.db.WithContext(ctx).")
+	g.P("    Where(", scoped(tenant, `"key": key`), ").")
+	g.P("    Not(map[string]interface{}{\"status\": ", ledgerPackage.Ident("StatusConfirmed"), "}).")
+	g.P("    Order(\"version\").Find(&rows).Error")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return rows, nil")
+	g.P("}")
+	g.P()
+
+	generateMarkChainWriteDoc(g)
+	g.P("func (r *", modelName, "Repo) MarkChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", status, ", txHash string) error {")
+	g.P("  if !status.Valid() {")
+	g.P("    return fmt.Errorf(\"invalid chain status %q\", status)")
+	g.P("  }")
+	g.P("  if len(writes) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, tenant, "")
+	g.P("  updates := map[string]interface{}{\"status\": status}")
+	g.P("  if txHash != \"\" {")
+	g.P("    updates[\"tx_hash\"] = txHash")
+	g.P("  }")
+	g.P("  cond, args := ", storePackage.Ident("FieldVersions"), "(false, 1, writes)")
+	g.P("  res := r.db.WithContext(ctx).Model(&", modelName, "Chain{}).")
+	g.P("    Where(", scoped(tenant, `"key": key`), ").")
+	g.P("    Where(cond, args...).")
+	g.P("    Updates(updates)")
+	g.P("  if res.Error != nil {")
+	g.P("    return res.Error")
+	g.P("  }")
+	g.P("  if res.RowsAffected == 0 {")
+	g.P("    return fmt.Errorf(\"no chain rows for key %q at %v\", key, writes)")
+	g.P("  }")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	generateConfirmChainWrite(g, msg, modelName+"Repo")
+}
+
+// generateMarkChainWriteDoc generates the doc comment of MarkChainWrite.
+func generateMarkChainWriteDoc(g *protogen.GeneratedFile) {
+	g.P("// MarkChainWrite sets the ledger status of the chain rows of the record written")
+	g.P("// at writes, the fields and versions of the rows. Versions count per field, so a")
+	g.P("// version alone does not name the rows of one write. txHash is recorded when not")
+	g.P("// empty.")
+}
+
+// generateConfirmChainWrite generates ConfirmChainWrite on top of the
+// MarkChainWrite of any backend, as a method of recv.
+func generateConfirmChainWrite(g *protogen.GeneratedFile, msg *protogen.Message, recv string) {
+	g.P("// ConfirmChainWrite records that the chain rows of the record written at writes")
+	g.P("// landed on the ledger in txHash.")
+	g.P("func (r *", recv, ") ConfirmChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", txHash string) error {")
+	g.P("  if txHash == \"\" {")
+	g.P("    return fmt.Errorf(\"confirming chain write of %q: empty tx hash\", key)")
+	g.P("  }")
+	g.P("  return r.MarkChainWrite(ctx, key, writes, ", ledgerPackage.Ident("StatusConfirmed"), ", txHash)")
+	g.P("}")
+	g.P()
+}
+
+// generateCompactChain generates the method pruning the confirmed chain
+// history of every record, of every tenant, with query.
+func generateCompactChain(g *protogen.GeneratedFile, msg *protogen.Message, query string) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// CompactChain deletes the chain rows created before the given time that are")
+	g.P("// confirmed on the ledger and superseded: the latest keepVersions versions up to")
+	g.P("// the latest confirmed version of every field are kept, as are the rows not")
+	g.P("// confirmed yet. It spans every record, and every tenant, and returns the")
+	g.P("// number of rows deleted. Pruned versions can no longer be verified.")
+	g.P("func (r *", modelName, "Repo) CompactChain(ctx context.Context, before ", timePackage.Ident("Time"), ", keepVersions int) (int64, error) {")
+	g.P("  if keepVersions < 1 {")
+	g.P("    return 0, fmt.Errorf(\"compacting chain: keepVersions must be at least 1, got %d\", keepVersions)")
+	g.P("  }")
+	g.P("  res := r.db.WithContext(ctx).Exec(", fmt.Sprintf("%q", query), ", before, keepVersions)")
+	g.P("  if res.Error != nil {")
+	g.P("    return 0, res.Error")
+	g.P("  }")
+	g.P("  return res.RowsAffected, nil")
+	g.P("}")
+	g.P()
+}
+
+// primaryKeyField returns the field of msg annotated with (sdm.primary_key),
+// or nil if there is none.
+func primaryKeyField(msg *protogen.Message) *protogen.Field {
+	for _, field := range msg.Fields {
+		if getFieldOptions(field).PrimaryKey {
+			return field
+		}
+	}
+	return nil
+}
+
+// recordKeyField returns the Go field of the column the view of msg is
+// looked up by.
+func recordKeyField(msg *protogen.Message) string {
+	if pk := primaryKeyField(msg); pk != nil {
+		return pk.GoName
 	}
+	return "Id"
+}
+
+// recordKeyName returns the column the view of msg is looked up by: its
+// primary key, or id.
+func recordKeyName(msg *protogen.Message) string {
+	if pk := primaryKeyField(msg); pk != nil {
+		return string(pk.Desc.Name())
+	}
+	return "id"
 }
 
 type SdmOptions struct {
@@ -274,10 +484,18 @@ type SdmOptions struct {
 	Pii                bool
 	QueryIndex         bool
 	Hashed             bool
+	// References is the name of the message whose primary key the field
+	// holds, see ValidateReferences.
+	References string
 }
 
 func getFieldOptions(field *protogen.Field) SdmOptions {
-	opts := field.Desc.Options()
+	return FieldOptions(field.Desc)
+}
+
+// FieldOptions returns the SDM annotations of a field.
+func FieldOptions(field protoreflect.FieldDescriptor) SdmOptions {
+	opts := field.Options()
 
 	// Helper to safe get bool
 	getBool := func(ext protoreflect.ExtensionType) bool {
@@ -293,6 +511,7 @@ func getFieldOptions(field *protogen.Field) SdmOptions {
 		Pii:                getBool(sdm.E_Pii),
 		QueryIndex:         getBool(sdm.E_QueryIndex),
 		Hashed:             getBool(sdm.E_Hashed),
+		References:         proto.GetExtension(opts, sdm.E_References).(string),
 	}
 }
 
@@ -307,14 +526,3 @@ func goTypeForField(field *protogen.Field) string {
 		return "string"
 	}
 }
-
-func sqlTypeForField(field *protogen.Field) string {
-	switch field.Desc.Kind() {
-	case protoreflect.StringKind:
-		return "TEXT"
-	case protoreflect.Int64Kind, protoreflect.Int32Kind:
-		return "BIGINT"
-	default:
-		return "TEXT"
-	}
-}
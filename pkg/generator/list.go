@@ -0,0 +1,145 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// filterFields returns the fields of msg List filters by: its query_index
+// fields and its chain fields.
+func filterFields(msg *protogen.Message) []*protogen.Field {
+	var fields []*protogen.Field
+	for _, field := range msg.Fields {
+		opts := getFieldOptions(field)
+		if opts.QueryIndex || !(opts.PrimaryKey || opts.Pii) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// generateFilterFields generates the <Msg>Fields variable of the typed
+// fields List filters by.
+func generateFilterFields(g *protogen.GeneratedFile, msg *protogen.Message) {
+	fields := filterFields(msg)
+	if len(fields) == 0 {
+		return
+	}
+	modelName := msg.GoIdent.GoName
+	field := storePackage.Ident("Field")
+
+	g.P("// ", modelName, "Fields are the fields the List of the ", modelName, " repositories filters")
+	g.P("// by, e.g. ", modelName, "Fields.", fields[0].GoName, ".Eq(v).")
+	g.P("var ", modelName, "Fields = struct {")
+	for _, f := range fields {
+		g.P(f.GoName, " ", field, "[", goTypeForField(f), "]")
+	}
+	g.P("}{")
+	for _, f := range fields {
+		g.P(f.GoName, ": ", field, "[", goTypeForField(f), "]{Column: ", fmt.Sprintf("%q", f.Desc.Name()), "},")
+	}
+	g.P("}")
+	g.P()
+}
+
+// listColumns returns the Go source of the map of the columns of msg List
+// filters by to their quoted names.
+func listColumns(msg *protogen.Message, d Dialect) string {
+	var entries []string
+	for _, f := range filterFields(msg) {
+		name := string(f.Desc.Name())
+		entries = append(entries, fmt.Sprintf("%q: %q", name, d.Quote(name)))
+	}
+	return "map[string]string{" + strings.Join(entries, ", ") + "}"
+}
+
+// listVar returns the name of the package variable holding the columns or
+// query List of msg reads.
+func listVar(msg *protogen.Message) string {
+	name := msg.GoIdent.GoName
+	return strings.ToLower(name[:1]) + name[1:] + "List"
+}
+
+const listDoc = "// List returns a page of the views of the records matching all of opts.Filters,\n" +
+	"// ordered by primary key, and the token of the next page, which is empty\n" +
+	"// after the last page."
+
+// generateGormList generates the GORM List of a message.
+func generateGormList(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	columns := listVar(msg) + "Columns"
+	key := d.Quote(recordKeyName(msg))
+
+	g.P("var ", columns, " = ", listColumns(msg, d))
+	g.P()
+	g.P(listDoc)
+	g.P("func (r *", modelName, "Repo) List(ctx context.Context, opts ", storePackage.Ident("ListOptions"), ") ([]", modelName, "View, string, error) {")
+	generateRequireTenant(g, tenant, `nil, ""`)
+	g.P("  after, paged, err := opts.After()")
+	g.P("  if err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	g.P("  where, args, err := ", storePackage.Ident("Where"), "(opts.Filters, ", columns, ", false, 1)")
+	g.P("  if err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	if tenant {
+		g.P("  q := r.db.WithContext(ctx).Where(", scoped(tenant), ")")
+	} else {
+		g.P("  q := r.db.WithContext(ctx)")
+	}
+	g.P("  if where != \"\" {")
+	g.P("    q = q.Where(where, args...)")
+	g.P("  }")
+	g.P("  cmp, order := \">\", \"ASC\"")
+	g.P("  if opts.Descending {")
+	g.P("    cmp, order = \"<\", \"DESC\"")
+	g.P("  }")
+	g.P("  if paged {")
+	g.P("    q = q.Where(", fmt.Sprintf("%q", key+" "), "+cmp+\" ?\", after)")
+	g.P("  }")
+	g.P("  var views []", modelName, "View")
+	g.P("  if err := q.Order(", fmt.Sprintf("%q", key+" "), " + order).Limit(opts.PageSize() + 1).Find(&views).Error; err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	g.P("  views, next := ", storePackage.Ident("Page"), "(views, opts, func(view ", modelName, "View) string { return view.", recordKeyField(msg), " })")
+	g.P("  return views, next, nil")
+	g.P("}")
+	g.P()
+}
+
+// generateSQLList generates the List of the database/sql and pgx
+// repositories of a message, which read views with their queryViews.
+func generateSQLList(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	query, _ := selectViewQuery(msg, d, t)
+
+	g.P("var ", listVar(msg), " = ", storePackage.Ident("ListQuery"), "{")
+	g.P("  Select: ", fmt.Sprintf("%q", query), ",")
+	g.P("  Key: ", fmt.Sprintf("%q", d.Quote(recordKeyName(msg))), ",")
+	g.P("  Columns: ", listColumns(msg, d), ",")
+	g.P("  Numbered: ", numberedPlaceholders(d), ",")
+	g.P("}")
+	g.P()
+	g.P(listDoc)
+	g.P("func (r *", modelName, "Repo) List(ctx context.Context, opts ", storePackage.Ident("ListOptions"), ") ([]", modelName, "View, string, error) {")
+	generateRequireTenant(g, t.Tenant, `nil, ""`)
+	if t.Tenant {
+		g.P("  query, args, err := ", listVar(msg), ".Build(opts, ", quotedQuery(d, sqlCondition(d, t)), ", tenantID)")
+	} else {
+		g.P("  query, args, err := ", listVar(msg), ".Build(opts, \"\")")
+	}
+	g.P("  if err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	g.P("  views, err := r.queryViews(ctx, query, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, \"\", err")
+	g.P("  }")
+	g.P("  views, next := ", storePackage.Ident("Page"), "(views, opts, func(view ", modelName, "View) string { return view.", recordKeyField(msg), " })")
+	g.P("  return views, next, nil")
+	g.P("}")
+	g.P()
+}
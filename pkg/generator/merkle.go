@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const merklePackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/merkle")
+
+// generateMerkleMethods generates the Merkle commitment methods of a message.
+// The MerklePayload of a multi-tenant message takes the tenant of the record.
+func generateMerkleMethods(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// MerkleTree returns the Merkle tree over the chain fields of the record.")
+	g.P("func (x *", modelName, ") MerkleTree() (*", merklePackage.Ident("Tree"), ", error) {")
+	g.P("  return ", merklePackage.Ident("New"), "(x.ChainFields())")
+	g.P("}")
+	g.P()
+
+	g.P("// MerkleProof returns the inclusion proof of the named chain field of the record.")
+	g.P("func (x *", modelName, ") MerkleProof(field string) (*", merklePackage.Ident("Proof"), ", error) {")
+	g.P("  tree, err := x.MerkleTree()")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return tree.Prove(field)")
+	g.P("}")
+	g.P()
+
+	g.P("// MerklePayload returns the canonical encoding of the Merkle root of the record.")
+	if tenant {
+		g.P("func (x *", modelName, ") MerklePayload(tenantID string) ([]byte, error) {")
+	} else {
+		g.P("func (x *", modelName, ") MerklePayload() ([]byte, error) {")
+	}
+	g.P("  tree, err := x.MerkleTree()")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  root := []", ledgerPackage.Ident("Field"), "{{Name: ", merklePackage.Ident("RootField"), ", Value: tree.RootHex()}}")
+	g.P("  return ", ledgerPayload(g, msg, tenant, payloadKey(g, msg), "root"))
+	g.P("}")
+	g.P()
+}
+
+// generateMerkleRepoMethods generates the repository methods checking
+// disclosed fields against the Merkle roots stored in the chain table.
+func generateMerkleRepoMethods(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// MerkleRoot returns the latest Merkle root committed for the record.")
+	g.P("func (r *", modelName, "Repo) MerkleRoot(ctx context.Context, key string) (string, error) {")
+	generateRequireTenant(g, tenant, `""`)
+	g.P("  var row ", modelName, "Chain")
+	g.P("  err := r.db.WithContext(ctx).")
+	g.P("    Where(", scoped(tenant, `"key": key`, `"field_name": `+g.QualifiedGoIdent(merklePackage.Ident("RootField"))), ").")
+	g.P("    Order(\"version DESC\").First(&row).Error")
+	g.P("  if err != nil {")
+	g.P("    return \"\", err")
+	g.P("  }")
+	g.P("  return row.FieldValue, nil")
+	g.P("}")
+	g.P()
+
+	generateVerifyFieldProof(g, msg, modelName+"Repo")
+}
+
+// generateVerifyFieldProof generates VerifyFieldProof on top of the
+// MerkleRoot of any backend, as a method of recv.
+func generateVerifyFieldProof(g *protogen.GeneratedFile, msg *protogen.Message, recv string) {
+	g.P("// VerifyFieldProof reports whether proof discloses a chain field of the record")
+	g.P("// under its latest Merkle root.")
+	g.P("func (r *", recv, ") VerifyFieldProof(ctx context.Context, key string, proof *", merklePackage.Ident("Proof"), ") (bool, error) {")
+	g.P("  root, err := r.MerkleRoot(ctx, key)")
+	g.P("  if err != nil {")
+	g.P("    return false, err")
+	g.P("  }")
+	g.P("  return proof.Verify(root), nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,359 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Snapshot is the schema generated for a set of proto files, stored next to
+// the migrations so the next migration can be computed against it.
+type Snapshot struct {
+	Dialect string  `json:"dialect"`
+	Tables  []Table `json:"tables"`
+	// Roles are granted access to the tables, as AccessStatements grants it.
+	Roles Roles `json:"roles,omitempty"`
+}
+
+// NewSnapshot returns the snapshot of the messages of the files to generate
+// with opts, for the dialect and roles of opts.
+func NewSnapshot(opts Options, files []*protogen.File) Snapshot {
+	s := Snapshot{Dialect: opts.Dialect, Roles: opts.Roles}
+	for _, f := range files {
+		if !f.Generate {
+			continue
+		}
+		for _, msg := range f.Messages {
+			s.Tables = append(s.Tables, NewTable(msg, opts))
+		}
+	}
+	sort.Slice(s.Tables, func(i, j int) bool { return s.Tables[i].Name < s.Tables[j].Name })
+	return s
+}
+
+func (s Snapshot) table(name string) (Table, bool) {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Table{}, false
+}
+
+// MigrationsTable records the migrations applied to a database.
+const MigrationsTable = "sdm_schema_migrations"
+
+// CreateMigrationsTable returns the statement creating the table recording
+// applied migrations.
+func CreateMigrationsTable(d Dialect) string {
+	lines := []string{
+		"  version " + d.ColumnType(ColumnInteger, true) + " PRIMARY KEY",
+		"  name " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	}
+	return "CREATE TABLE IF NOT EXISTS " + MigrationsTable + " (\n" + strings.Join(lines, ",\n") + "\n)"
+}
+
+// MigrationsTableExists returns the query counting the tables called
+// MigrationsTable in the schema of the connection: 1 once it is created.
+func MigrationsTableExists(d Dialect) string {
+	switch d.Name() {
+	case DialectSQLite:
+		return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = '" + MigrationsTable + "'"
+	case DialectMySQL:
+		return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = '" + MigrationsTable + "'"
+	}
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = '" + MigrationsTable + "'"
+}
+
+// Migration holds the statements migrating a schema up from one snapshot to
+// another, and back down.
+type Migration struct {
+	Up   []string
+	Down []string
+	// Reclassifications are the fields the migration reclassifies.
+	Reclassifications []Reclassification
+}
+
+// Empty reports whether the migration has no statements.
+func (m Migration) Empty() bool {
+	return len(m.Up) == 0 && len(m.Down) == 0
+}
+
+// Diff returns the migration from the schema of snapshot from to the schema
+// of snapshot to.
+func Diff(d Dialect, from, to Snapshot) (Migration, error) {
+	var m Migration
+	// The migrations of the tables run in the order of their references,
+	// and back down in reverse.
+	var downs [][]string
+
+	for _, t := range OrderByReferences(to.Tables) {
+		old, ok := from.table(t.Name)
+		if !ok {
+			// New message
+			m.Up = append(m.Up, SchemaStatements(d, t)...)
+			m.Up = append(m.Up, AccessStatements(d, t, to.Roles)...)
+			downs = append(downs, DropStatements(d, t))
+			continue
+		}
+		changed, err := diffTable(d, old, t, from.Roles, to.Roles)
+		if err != nil {
+			return Migration{}, err
+		}
+		m.Up = append(m.Up, changed.Up...)
+		downs = append(downs, changed.Down)
+		m.Reclassifications = append(m.Reclassifications, changed.Reclassifications...)
+	}
+
+	removed := OrderByReferences(from.Tables)
+	for i := len(removed) - 1; i >= 0; i-- {
+		old := removed[i]
+		if _, ok := to.table(old.Name); !ok {
+			// Removed message
+			m.Up = append(m.Up, DropStatements(d, old)...)
+			downs = append(downs, append(SchemaStatements(d, old), AccessStatements(d, old, from.Roles)...))
+		}
+	}
+	for i := len(downs) - 1; i >= 0; i-- {
+		m.Down = append(m.Down, downs[i]...)
+	}
+
+	if len(m.Reclassifications) > 0 {
+		m.Up = append([]string{CreateReclassificationTable(d)}, m.Up...)
+	}
+	return m, nil
+}
+
+// DropStatements returns the statements dropping the views and tables of t,
+// with the redacted view of AccessStatements if it was created.
+func DropStatements(d Dialect, t Table) []string {
+	return []string{
+		"DROP VIEW IF EXISTS " + t.RedactedView(),
+		DropView(d, t),
+		"DROP TABLE IF EXISTS " + t.OutboxTable(),
+		"DROP TABLE IF EXISTS " + t.ChainTable(),
+		"DROP TABLE IF EXISTS " + t.PiiTable(),
+	}
+}
+
+// DropView returns the statement dropping the view of t.
+func DropView(d Dialect, t Table) string {
+	return "DROP VIEW IF EXISTS " + t.View()
+}
+
+// diffTable returns the migration between two versions of the layout of a
+// message, whose tables are granted to fromRoles and toRoles. The view is
+// dropped first and recreated last, as its columns depend on the PII table.
+// So is the access of the roles: their redacted view reads the PII table too.
+func diffTable(d Dialect, from, to Table, fromRoles, toRoles Roles) (Migration, error) {
+	if fmt.Sprint(from.PrimaryKey()) != fmt.Sprint(to.PrimaryKey()) {
+		return Migration{}, fmt.Errorf("%s: changing the primary key from %v to %v is not supported", to.Message, from.PrimaryKey(), to.PrimaryKey())
+	}
+	if from.Tenant != to.Tenant {
+		return Migration{}, fmt.Errorf("%s: changing the tenant mode is not supported", to.Message)
+	}
+	if from.ChainPartition != to.ChainPartition || from.ChainPartitions != to.ChainPartitions {
+		return Migration{}, fmt.Errorf("%s: changing the partitioning of %s is not supported", to.Message, to.ChainTable())
+	}
+
+	oldFields := make(map[string]TableField, len(from.Fields))
+	for _, f := range from.Fields {
+		oldFields[f.Name] = f
+	}
+	newFields := make(map[string]TableField, len(to.Fields))
+	for _, f := range to.Fields {
+		newFields[f.Name] = f
+	}
+
+	var steps []Migration
+	var reclassified []Reclassification
+	viewChanged := len(from.Fields) != len(to.Fields)
+	for i, f := range to.Fields {
+		old, ok := oldFields[f.Name]
+		if !ok {
+			old = TableField{Name: f.Name, Type: f.Type}
+		}
+		if !ok || i >= len(from.Fields) || from.Fields[i] != f {
+			viewChanged = true
+		}
+		step, err := diffField(d, to, old, f)
+		if err != nil {
+			return Migration{}, err
+		}
+		if !ok || !Reclassified(old, f) {
+			steps = append(steps, step...)
+			continue
+		}
+
+		// The data moves while the field has a PII column: after it is
+		// added, before it is dropped.
+		up, r := reclassify(d, to, old, f)
+		down, _ := reclassify(d, to, f, old)
+		data := Migration{Up: up, Down: down}
+		if old.InPii() && !f.InPii() {
+			steps = append(steps, data)
+			steps = append(steps, step...)
+		} else {
+			steps = append(steps, step...)
+			steps = append(steps, data)
+		}
+		reclassified = append(reclassified, r)
+	}
+	if from.RowVersion != to.RowVersion {
+		viewChanged = true
+		add := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", to.PiiTable(), rowVersionColumnDefinition(d))
+		drop := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", to.PiiTable(), d.Quote(RowVersionColumn))
+		if to.RowVersion {
+			steps = append(steps, Migration{Up: []string{add}, Down: []string{drop}})
+		} else {
+			steps = append(steps, Migration{Up: []string{drop}, Down: []string{add}})
+		}
+	}
+	if from.SoftDelete != to.SoftDelete {
+		viewChanged = true
+		add := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", to.PiiTable(), deletedAtColumnDefinition(d))
+		drop := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", to.PiiTable(), d.Quote(DeletedAtColumn))
+		if to.SoftDelete {
+			steps = append(steps, Migration{Up: []string{add}, Down: []string{drop}})
+		} else {
+			steps = append(steps, Migration{Up: []string{drop}, Down: []string{add}})
+		}
+	}
+	for _, old := range from.Fields {
+		if _, ok := newFields[old.Name]; !ok {
+			// Removed field
+			step, err := diffField(d, to, old, TableField{Name: old.Name, Type: old.Type})
+			if err != nil {
+				return Migration{}, err
+			}
+			steps = append(steps, step...)
+		}
+	}
+
+	recreateView := len(steps) > 0 || viewChanged
+	if !recreateView && fromRoles == toRoles {
+		return Migration{}, nil
+	}
+
+	m := Migration{
+		Up:                DropAccessStatements(d, from, fromRoles),
+		Down:              DropAccessStatements(d, to, toRoles),
+		Reclassifications: reclassified,
+	}
+	if recreateView {
+		m.Up = append(m.Up, DropView(d, to))
+		m.Down = append(m.Down, DropView(d, from))
+	}
+	for _, step := range steps {
+		m.Up = append(m.Up, step.Up...)
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		m.Down = append(m.Down, steps[i].Down...)
+	}
+	if recreateView {
+		m.Up = append(m.Up, CreateView(d, to)...)
+		m.Down = append(m.Down, CreateView(d, from)...)
+	}
+	m.Up = append(m.Up, AccessStatements(d, to, toRoles)...)
+	m.Down = append(m.Down, AccessStatements(d, from, fromRoles)...)
+	return m, nil
+}
+
+// diffField returns the steps migrating the PII table of t from one
+// classification of a field to another. A field absent from one side is
+// passed as a chain field, which has no column. The foreign key of the field
+// is dropped before its column changes and added after.
+func diffField(d Dialect, t Table, from, to TableField) ([]Migration, error) {
+	steps, err := diffColumn(d, t, from, to)
+	if err != nil {
+		return nil, err
+	}
+	fromKey, toKey := foreignKey(d, t, from), foreignKey(d, t, to)
+	if fromKey == toKey {
+		return steps, nil
+	}
+	table, name := t.PiiTable(), t.ForeignKeyName(to.Name)
+	drop, ok := d.DropForeignKey(table, name)
+	if !ok {
+		return nil, fmt.Errorf("%s: changing the references of %s is not supported by %s", t.Message, to.Name, d.Name())
+	}
+	if fromKey != "" {
+		add, _ := d.AddForeignKey(table, fromKey)
+		steps = append([]Migration{{Up: []string{drop}, Down: []string{add}}}, steps...)
+	}
+	if toKey != "" {
+		add, _ := d.AddForeignKey(table, toKey)
+		steps = append(steps, Migration{Up: []string{add}, Down: []string{drop}})
+	}
+	return steps, nil
+}
+
+// foreignKey returns the constraint of the foreign key of field, or "" if it
+// is not one.
+func foreignKey(d Dialect, t Table, field TableField) string {
+	if field.References == "" || !field.InPii() {
+		return ""
+	}
+	return ForeignKey(d, t, field)
+}
+
+// diffColumn returns the steps migrating the PII column of a field.
+func diffColumn(d Dialect, t Table, from, to TableField) ([]Migration, error) {
+	table := t.PiiTable()
+	column := d.Quote(to.Name)
+	index := t.IndexName(to.Name)
+	addColumn := func(f TableField) []string {
+		stmts := []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, d.ColumnType(f.Type, f.QueryIndex))}
+		if f.QueryIndex && !f.PrimaryKey {
+			stmts = append(stmts, d.CreateIndex(index, table, indexColumns(d, t, to.Name))...)
+		}
+		return stmts
+	}
+	// SQLite cannot drop an indexed column, so the index goes first.
+	dropColumn := func(f TableField) []string {
+		var stmts []string
+		if f.QueryIndex && !f.PrimaryKey {
+			stmts = append(stmts, d.DropIndex(index, table))
+		}
+		return append(stmts, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+	}
+
+	switch {
+	case !from.InPii() && to.InPii():
+		return []Migration{{Up: addColumn(to), Down: dropColumn(to)}}, nil
+	case from.InPii() && !to.InPii():
+		return []Migration{{Up: dropColumn(from), Down: addColumn(from)}}, nil
+	case !from.InPii() && !to.InPii():
+		return nil, nil
+	}
+
+	var steps []Migration
+	fromType, toType := d.ColumnType(from.Type, from.QueryIndex), d.ColumnType(to.Type, to.QueryIndex)
+	if fromType != toType {
+		up, ok := d.AlterColumnType(table, column, toType)
+		if !ok {
+			return nil, fmt.Errorf("%s: changing the type of %s from %s to %s is not supported by %s", t.Message, to.Name, fromType, toType, d.Name())
+		}
+		down, _ := d.AlterColumnType(table, column, fromType)
+		steps = append(steps, Migration{Up: []string{up}, Down: []string{down}})
+	}
+
+	fromIndexed := from.QueryIndex && !from.PrimaryKey
+	toIndexed := to.QueryIndex && !to.PrimaryKey
+	switch {
+	case !fromIndexed && toIndexed:
+		steps = append(steps, Migration{
+			Up:   d.CreateIndex(index, table, indexColumns(d, t, to.Name)),
+			Down: []string{d.DropIndex(index, table)},
+		})
+	case fromIndexed && !toIndexed:
+		steps = append(steps, Migration{
+			Up:   []string{d.DropIndex(index, table)},
+			Down: d.CreateIndex(index, table, indexColumns(d, t, to.Name)),
+		})
+	}
+	return steps, nil
+}
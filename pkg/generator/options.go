@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Ledger payloads queued by Save.
+const (
+	// LedgerPayloadFields publishes the canonical chain payload of the record.
+	LedgerPayloadFields = "fields"
+	// LedgerPayloadMerkle publishes only the Merkle root over the chain fields.
+	LedgerPayloadMerkle = "merkle"
+)
+
+// Backends the generated repositories are written against.
+const (
+	// BackendGORM generates repositories on gorm.io/gorm.
+	BackendGORM = "gorm"
+	// BackendSQL generates repositories on database/sql only.
+	BackendSQL = "sql"
+	// BackendPgx generates repositories on github.com/jackc/pgx/v5, for
+	// Postgres only.
+	BackendPgx = "pgx"
+)
+
+// Partitionings of the chain tables. Postgres only.
+const (
+	// ChainPartitionRange partitions chain tables by range of created_at.
+	// Only a default partition is generated; the partitions of the ranges
+	// are created by the operator.
+	ChainPartitionRange = "range"
+	// ChainPartitionHash partitions chain tables by hash of the record key.
+	ChainPartitionHash = "hash"
+)
+
+// DefaultChainPartitions is the number of partitions of hash-partitioned
+// chain tables.
+const DefaultChainPartitions = 8
+
+// Options configures the artifacts generated for a proto file.
+type Options struct {
+	// LedgerPayload selects what Save queues in the outbox for the ledger.
+	// Defaults to LedgerPayloadFields.
+	LedgerPayload string
+	// Dialect is the SQL dialect of the generated schema, see LookupDialect.
+	// Defaults to DialectPostgres.
+	Dialect string
+	// Backend selects the database API of the generated repositories.
+	// Defaults to BackendGORM.
+	Backend string
+	// Roles are granted access to the generated tables. Postgres only.
+	Roles Roles
+	// MultiTenant scopes every message by tenant, as (sdm.multi_tenant)
+	// does for the messages of a file.
+	MultiTenant bool
+	// SoftDelete gives every message a soft Delete, as (sdm.soft_delete)
+	// does for the messages of a file.
+	SoftDelete bool
+	// ChainPartition partitions the chain tables, see ChainPartitionRange
+	// and ChainPartitionHash. Postgres only. Defaults to no partitioning.
+	ChainPartition string
+	// ChainPartitions is the number of partitions of hash-partitioned chain
+	// tables. Defaults to DefaultChainPartitions.
+	ChainPartitions int
+}
+
+// chainPartitions returns the number of hash partitions of the chain tables,
+// or 0 when they are not hash-partitioned.
+func (o Options) chainPartitions() int {
+	switch {
+	case o.ChainPartition != ChainPartitionHash:
+		return 0
+	case o.ChainPartitions == 0:
+		return DefaultChainPartitions
+	default:
+		return o.ChainPartitions
+	}
+}
+
+// Roles names the database roles the generated schema grants access to.
+// Unset roles get no grants.
+type Roles struct {
+	// PiiReader may read the PII tables and the views.
+	PiiReader string `json:"pii_reader,omitempty"`
+	// Public may read only the chain tables and the redacted views.
+	Public string `json:"public,omitempty"`
+}
+
+// Empty reports whether no role is set.
+func (r Roles) Empty() bool {
+	return r.PiiReader == "" && r.Public == ""
+}
+
+var roleNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate reports an error for unknown option values.
+func (o Options) Validate() error {
+	switch o.LedgerPayload {
+	case "", LedgerPayloadFields, LedgerPayloadMerkle:
+	default:
+		return fmt.Errorf("unknown ledger payload %q (want %q or %q)", o.LedgerPayload, LedgerPayloadFields, LedgerPayloadMerkle)
+	}
+	switch o.Backend {
+	case "", BackendGORM, BackendSQL, BackendPgx:
+	default:
+		return fmt.Errorf("unknown backend %q (want %q, %q or %q)", o.Backend, BackendGORM, BackendSQL, BackendPgx)
+	}
+	d, err := LookupDialect(o.Dialect)
+	if err != nil {
+		return err
+	}
+	if !o.Roles.Empty() && d.Name() != DialectPostgres {
+		return fmt.Errorf("roles are supported by %s only, not %s", DialectPostgres, d.Name())
+	}
+	if o.Backend == BackendPgx && d.Name() != DialectPostgres {
+		return fmt.Errorf("backend %s supports %s only, not %s", BackendPgx, DialectPostgres, d.Name())
+	}
+	switch o.ChainPartition {
+	case "", ChainPartitionRange, ChainPartitionHash:
+	default:
+		return fmt.Errorf("unknown chain partition %q (want %q or %q)", o.ChainPartition, ChainPartitionRange, ChainPartitionHash)
+	}
+	if o.ChainPartition != "" && d.Name() != DialectPostgres {
+		return fmt.Errorf("chain partitioning is supported by %s only, not %s", DialectPostgres, d.Name())
+	}
+	if o.ChainPartitions < 0 || (o.ChainPartitions > 0 && o.ChainPartition != ChainPartitionHash) {
+		return fmt.Errorf("chain partitions must be a positive number of %s partitions", ChainPartitionHash)
+	}
+	for _, role := range []string{o.Roles.PiiReader, o.Roles.Public} {
+		if role != "" && !roleNameRe.MatchString(role) {
+			return fmt.Errorf("invalid role name %q", role)
+		}
+	}
+	return nil
+}
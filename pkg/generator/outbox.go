@@ -0,0 +1,177 @@
+package generator
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	jsonPackage   = protogen.GoImportPath("encoding/json")
+	timePackage   = protogen.GoImportPath("time")
+	outboxPackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/outbox")
+)
+
+// generateOutboxModel generates the row type of the outbox table of a message.
+func generateOutboxModel(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("type ", modelName, "OutboxEntry struct {")
+	g.P("ID int64 `gorm:\"primaryKey;column:id;autoIncrement\"`")
+	if tenant {
+		g.P("TenantID string `gorm:\"column:tenant_id\"`")
+	}
+	g.P("Key string `gorm:\"column:key\"`")
+	g.P("Versions string `gorm:\"column:versions\"`") // JSON array of the ledger.FieldVersion of the chain rows carried by the payload
+	g.P("Payload []byte `gorm:\"column:payload\"`")
+	g.P("Status string `gorm:\"column:status;default:pending\"`")
+	g.P("Attempts int `gorm:\"column:attempts\"`")
+	g.P("NextAttemptAt time.Time `gorm:\"column:next_attempt_at\"`")
+	g.P("LastError string `gorm:\"column:last_error\"`")
+	g.P("TxHash string `gorm:\"column:tx_hash\"`")
+	g.P("DeliveredAt *time.Time `gorm:\"column:delivered_at\"`")
+	g.P("CreatedAt time.Time `gorm:\"column:created_at\"`")
+	g.P("}")
+	g.P()
+	g.P("func (", modelName, "OutboxEntry) TableName() string { return \"outbox_", strings.ToLower(modelName), "s\" }")
+	g.P()
+}
+
+// generateOutboxPayload generates the payload and versionsJSON variables of
+// the outbox entry of model, returning err from the enclosing function.
+func generateOutboxPayload(g *protogen.GeneratedFile, opts Options, tenant bool, indent string) {
+	generateLedgerPayload(g, opts, tenant, indent)
+	g.P(indent, "versionsJSON, err := ", jsonPackage.Ident("Marshal"), "(writes)")
+	g.P(indent, "if err != nil { return err }")
+}
+
+// generateLedgerPayload generates the payload variable of the outbox entry
+// of model, returning err from the enclosing function. The payload of a
+// multi-tenant message carries the tenantID variable.
+func generateLedgerPayload(g *protogen.GeneratedFile, opts Options, tenant bool, indent string) {
+	arg := ""
+	if tenant {
+		arg = "tenantID"
+	}
+	if opts.LedgerPayload == LedgerPayloadMerkle {
+		g.P(indent, "payload, err := model.MerklePayload(", arg, ")")
+	} else {
+		g.P(indent, "payload, err := model.ChainPayload(", arg, ")")
+	}
+	g.P(indent, "if err != nil { return err }")
+}
+
+// generateOutboxStore generates the outbox.Store implementation of a message.
+func generateOutboxStore(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	entry := outboxPackage.Ident("Entry")
+	now := timePackage.Ident("Time")
+
+	g.P("// ", modelName, "Outbox is the ", outboxPackage.Ident("Store"), " of ", modelName, " chain writes.")
+	g.P("type ", modelName, "Outbox struct {")
+	g.P("  db *gorm.DB")
+	g.P("}")
+	g.P()
+	g.P("var _ ", outboxPackage.Ident("Store"), " = (*", modelName, "Outbox)(nil)")
+	g.P()
+	g.P("func New", modelName, "Outbox(db *gorm.DB) *", modelName, "Outbox {")
+	g.P("  return &", modelName, "Outbox{db: db}")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) Pending(ctx context.Context, now ", now, ", limit int) ([]", entry, ", error) {")
+	g.P("  var rows []", modelName, "OutboxEntry")
+	g.P("  err := o.db.WithContext(ctx).")
+	g.P("    Where(map[string]interface{}{\"status\": ", outboxPackage.Ident("StatusPending"), "}).")
+	g.P("    Where(\"next_attempt_at <= ?\", now).")
+	g.P("    Order(\"id\").Limit(limit).Find(&rows).Error")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  entries := make([]", entry, ", 0, len(rows))")
+	g.P("  for _, row := range rows {")
+	if tenant {
+		g.P("    entries = append(entries, ", entry, "{ID: row.ID, TenantID: row.TenantID, Key: row.Key, Payload: row.Payload, Attempts: row.Attempts})")
+	} else {
+		g.P("    entries = append(entries, ", entry, "{ID: row.ID, Key: row.Key, Payload: row.Payload, Attempts: row.Attempts})")
+	}
+	g.P("  }")
+	g.P("  return entries, nil")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkDelivered(ctx context.Context, id int64, txHash string) error {")
+	g.P("  return o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+	g.P("    row, err := o.finish(tx, id, map[string]interface{}{")
+	g.P("      \"status\": ", outboxPackage.Ident("StatusDelivered"), ",")
+	g.P("      \"tx_hash\": txHash,")
+	g.P("      \"delivered_at\": ", timePackage.Ident("Now"), "(),")
+	g.P("    })")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(tx, row, ", ledgerPackage.Ident("StatusConfirmed"), ", txHash)")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkRetry(ctx context.Context, id int64, cause error, next ", now, ") error {")
+	g.P("  _, err := o.finish(o.db.WithContext(ctx), id, map[string]interface{}{")
+	g.P("    \"attempts\": gorm.Expr(\"attempts + 1\"),")
+	g.P("    \"last_error\": cause.Error(),")
+	g.P("    \"next_attempt_at\": next,")
+	g.P("  })")
+	g.P("  return err")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkFailed(ctx context.Context, id int64, cause error) error {")
+	g.P("  return o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+	g.P("    row, err := o.finish(tx, id, map[string]interface{}{")
+	g.P("      \"status\": ", outboxPackage.Ident("StatusFailed"), ",")
+	g.P("      \"attempts\": gorm.Expr(\"attempts + 1\"),")
+	g.P("      \"last_error\": cause.Error(),")
+	g.P("    })")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(tx, row, ", ledgerPackage.Ident("StatusFailed"), ", \"\")")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("// finish applies updates to a pending outbox entry and returns it.")
+	g.P("func (o *", modelName, "Outbox) finish(tx *gorm.DB, id int64, updates map[string]interface{}) (*", modelName, "OutboxEntry, error) {")
+	g.P("  res := tx.Model(&", modelName, "OutboxEntry{}).")
+	g.P("    Where(map[string]interface{}{\"id\": id, \"status\": ", outboxPackage.Ident("StatusPending"), "}).")
+	g.P("    Updates(updates)")
+	g.P("  if res.Error != nil {")
+	g.P("    return nil, res.Error")
+	g.P("  }")
+	g.P("  if res.RowsAffected == 0 {")
+	g.P("    return nil, fmt.Errorf(\"no pending outbox entry %d\", id)")
+	g.P("  }")
+	g.P("  var row ", modelName, "OutboxEntry")
+	g.P("  if err := tx.First(&row, id).Error; err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &row, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// markChain moves the chain rows carried by an outbox entry to status.")
+	g.P("func (o *", modelName, "Outbox) markChain(tx *gorm.DB, row *", modelName, "OutboxEntry, status ", ledgerPackage.Ident("Status"), ", txHash string) error {")
+	g.P("  var writes []", ledgerPackage.Ident("FieldVersion"), "")
+	g.P("  if err := ", jsonPackage.Ident("Unmarshal"), "([]byte(row.Versions), &writes); err != nil {")
+	g.P("    return fmt.Errorf(\"outbox entry %d: decoding versions: %w\", row.ID, err)")
+	g.P("  }")
+	if tenant {
+		// The dispatcher serves every tenant; the entry names the one of the rows.
+		g.P("  ctx := ", tenantPackage.Ident("With"), "(tx.Statement.Context, row.TenantID)")
+		g.P("  return New", modelName, "Repo(tx).MarkChainWrite(ctx, row.Key, writes, status, txHash)")
+	} else {
+		g.P("  return New", modelName, "Repo(tx).MarkChainWrite(tx.Statement.Context, row.Key, writes, status, txHash)")
+	}
+	g.P("}")
+	g.P()
+}
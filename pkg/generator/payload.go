@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const fmtPackage = protogen.GoImportPath("fmt")
+
+// ValidateChainFields reports an error for a field of a message of file whose
+// value is published, as a chain field, a hash or the key of the record, but
+// is not a scalar. Published values are formatted with %v, which is canonical
+// for scalars only: the text of messages is unstable by design.
+func ValidateChainFields(file *protogen.File) error {
+	for _, msg := range file.Messages {
+		for _, field := range msg.Fields {
+			opts := getFieldOptions(field)
+			if opts.Pii && !opts.Hashed && !opts.PrimaryKey {
+				continue
+			}
+			var kind string
+			switch {
+			case field.Desc.IsMap():
+				kind = "map"
+			case field.Desc.IsList():
+				kind = "repeated"
+			case field.Desc.Kind() == protoreflect.MessageKind || field.Desc.Kind() == protoreflect.GroupKind:
+				kind = "message"
+			default:
+				continue
+			}
+			return fmt.Errorf("%s.%s: a %s field has no canonical chain value; only scalar fields can be chain, hashed or primary_key fields", msg.Desc.Name(), field.Desc.Name(), kind)
+		}
+	}
+	return nil
+}
+
+// ledgerPayload returns the Go source of the canonical payload of fields, the
+// Go source of chain fields of the record of msg with the key key. The
+// payloads of multi-tenant messages carry the tenantID variable.
+func ledgerPayload(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool, key, fields string) string {
+	if tenant {
+		return fmt.Sprintf("%s(%q, tenantID, %s, %s)", g.QualifiedGoIdent(ledgerPackage.Ident("TenantPayload")), msg.Desc.FullName(), key, fields)
+	}
+	return fmt.Sprintf("%s(%q, %s, %s)", g.QualifiedGoIdent(ledgerPackage.Ident("Payload")), msg.Desc.FullName(), key, fields)
+}
+
+// payloadKey returns the Go source of the key of the payloads of a record of
+// msg, held by the receiver x.
+func payloadKey(g *protogen.GeneratedFile, msg *protogen.Message) string {
+	if pk := primaryKeyField(msg); pk != nil {
+		return g.QualifiedGoIdent(fmtPackage.Ident("Sprintf")) + "(\"%v\", x.Get" + pk.GoName + "())"
+	}
+	return `""`
+}
+
+// generateChainPayload generates the methods giving the canonical, public
+// representation of a message: its chain fields, their canonical payload and
+// the record digest. Those of a multi-tenant message take the tenant of the
+// record, which its payload carries.
+func generateChainPayload(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	field := ledgerPackage.Ident("Field")
+
+	g.P("// ChainFields returns the fields of the record written to the chain table,")
+	g.P("// followed by the hash of every hashed field.")
+	g.P("func (x *", modelName, ") ChainFields() []", field, " {")
+	g.P("  return []", field, "{")
+	for _, f := range msg.Fields {
+		opts := getFieldOptions(f)
+		if !opts.Pii {
+			g.P("    {Name: \"", f.Desc.Name(), "\", Value: ", fmtPackage.Ident("Sprintf"), "(\"%v\", x.Get", f.GoName, "())},")
+		}
+		if opts.Hashed {
+			g.P("    {Name: \"hashed_", f.Desc.Name(), "\", Value: ", ledgerPackage.Ident("Hash"), "(", fmtPackage.Ident("Sprintf"), "(\"%v\", x.Get", f.GoName, "()))},")
+		}
+	}
+	g.P("  }")
+	g.P("}")
+	g.P()
+
+	param, arg := "", ""
+	if tenant {
+		param, arg = "tenantID string", "tenantID"
+	}
+	g.P("// ChainPayload returns the canonical encoding of the chain fields of the record.")
+	g.P("func (x *", modelName, ") ChainPayload(", param, ") ([]byte, error) {")
+	g.P("  return ", ledgerPayload(g, msg, tenant, payloadKey(g, msg), "x.ChainFields()"))
+	g.P("}")
+	g.P()
+
+	g.P("// ChainDigest returns the record digest of the chain payload of the record.")
+	g.P("func (x *", modelName, ") ChainDigest(", param, ") (string, error) {")
+	g.P("  payload, err := x.ChainPayload(", arg, ")")
+	g.P("  if err != nil {")
+	g.P("    return \"\", err")
+	g.P("  }")
+	g.P("  return ", ledgerPackage.Ident("Digest"), "(payload), nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,573 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const pgxstorePackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/store/pgxstore")
+
+// generatePgxRepo generates the repositories of the messages of file on pgx,
+// with the queries of the Postgres dialect d.
+func generatePgxRepo(gen *protogen.Plugin, file *protogen.File, d Dialect, opts Options) {
+	filename := file.GeneratedFilenamePrefix + "_sdm_repo.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	g.P(`	"context"`)
+	g.P(`	"fmt"`)
+	g.P()
+	// Imported by hand: protogen would name the package after its v5 path.
+	g.P(`	"github.com/jackc/pgx/v5"`)
+	g.P(")")
+	g.P()
+
+	for _, msg := range file.Messages {
+		modelName := msg.GoIdent.GoName
+		t := NewTable(msg, opts)
+
+		generateStoreInterface(g, file, msg, t)
+
+		g.P("// ", modelName, "Repo stores ", modelName, " records with pgx.")
+		g.P("type ", modelName, "Repo struct {")
+		g.P("  db        ", pgxstorePackage.Ident("DB"))
+		g.P("  batchSize int")
+		g.P("}")
+		g.P()
+
+		g.P("// New", modelName, "Repo returns the repository of ", modelName, " records on db, a pgx pool,")
+		g.P("// connection or transaction.")
+		g.P("func New", modelName, "Repo(db ", pgxstorePackage.Ident("DB"), ") *", modelName, "Repo {")
+		g.P("  return &", modelName, "Repo{db: db, batchSize: ", storePackage.Ident("DefaultBatchSize"), "}")
+		g.P("}")
+		g.P()
+		g.P("var _ ", modelName, "Store = (*", modelName, "Repo)(nil)")
+		g.P()
+		generateWithBatchSize(g, msg)
+		generateWithTx(g, msg, "pgx.Tx", "db")
+
+		generatePgxSave(g, msg, d, t, opts)
+		generatePgxUpdate(g, msg, d, t, opts)
+		if t.SoftDelete {
+			generatePgxSoftDelete(g, msg, d, t)
+		}
+		generatePgxSaveMany(g, msg, d, t, opts)
+		generatePgxLatestVersions(g, msg, d, t)
+		generatePgxFetch(g, msg, d, t)
+		generateSQLFetchWith(g, file, msg, d, opts)
+		generateSQLList(g, msg, d, t)
+		generatePgxChainWriteMethods(g, msg, d, t)
+		generatePgxMerkleRoot(g, msg, d, t)
+		generateVerifyFieldProof(g, msg, modelName+"Repo")
+		generatePgxPublishedHashes(g, msg, d, t)
+		generateHashVerifyMethods(g, msg, modelName+"Repo")
+		generatePgxOutboxStore(g, msg, d, t)
+	}
+	generateFileStore(g, gen, file, opts.Backend, g.QualifiedGoIdent(pgxstorePackage.Ident("DB")))
+}
+
+// goStrings returns the Go source of a []string literal of values.
+func goStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+func generatePgxSave(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+
+	g.P("// Save writes the PII row, the chain rows and the outbox entry of model in one")
+	g.P("// batch: a single round trip, run as one transaction, or in a savepoint of the")
+	g.P("// transaction the repository runs on.")
+	g.P("func (r *", modelName, "Repo) Save(ctx context.Context, model *", modelName, ") error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	generatePgxSaveBatch(g, msg, d, t, opts, "r.db", quotedQuery(d, insertQuery(t.PiiTable(), columns.pii))+", "+strings.Join(columns.piiValues, ", "))
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
+
+// generatePgxSaveBatch generates the batch of the pgx Save and Update of msg
+// sent on db, the Go source of its pgxstore.DB: pii, the Go source of the
+// query and arguments inserting the PII row of model unless empty, then its
+// chain rows and outbox entry. The batch reads nothing beforehand: the chain
+// rows take their versions, and the outbox entry its versions, in the
+// statements inserting them.
+func generatePgxSaveBatch(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options, db, pii string) {
+	columns := newSaveColumns(msg, d, t)
+	key := "model." + columns.pkField
+
+	keyCond := sqlCondition(d, t, "key")
+	values := []string{"?", "?", "?", "COALESCE(MAX(version), 0) + 1", "?", "?"}
+	if !t.Tenant {
+		values = values[1:]
+	}
+	chainInsert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s",
+		t.ChainTable(), strings.Join(columns.chain, ", "), strings.Join(values, ", "), t.ChainTable(), sqlCondition(d, t, "key", "field_name"))
+	latest := fmt.Sprintf("SELECT field_name, MAX(version) AS version FROM %s WHERE %s AND field_name = ANY(?) GROUP BY field_name", t.ChainTable(), keyCond)
+	values = []string{"?", "?", "json_agg(json_build_object('field', field_name, 'version', version) ORDER BY field_name)::text", "?", "?", "?"}
+	if !t.Tenant {
+		values = values[1:]
+	}
+	outboxInsert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM (%s) v",
+		t.OutboxTable(), strings.Join(columns.outbox, ", "), strings.Join(values, ", "), latest)
+
+	g.P("    // Save Chain Fields")
+	g.P("    // Versions count per (key, field_name): each row takes the version after")
+	g.P("    // the latest of its field. A version taken by a concurrent writer fails")
+	g.P("    // the batch, which is retried.")
+	g.P("    now := ", timePackage.Ident("Now"), "()")
+	g.P("    batch := &pgx.Batch{}")
+	if pii != "" {
+		g.P("    batch.Queue(", pii, ")")
+	}
+	g.P("    var names []string")
+	g.P("    appendChain := func(name, value string) {")
+	g.P("      batch.Queue(", quotedQuery(d, chainInsert), ", ", sqlArgs(t, key, "name", "value", "now"), ", ", sqlArgs(t, key, "name"), ")")
+	g.P("      names = append(names, name)")
+	g.P("    }")
+	g.P("    for _, field := range model.ChainFields() {")
+	g.P("      appendChain(field.Name, field.Value)")
+	g.P("    }")
+	g.P()
+	g.P("    // Save Merkle Root of the Chain Fields")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil { return err }")
+	g.P("    appendChain(", merklePackage.Ident("RootField"), ", tree.RootHex())")
+	g.P()
+	g.P("    // Queue Chain Fields for the ledger, at the versions the rows above")
+	g.P("    // took, the latest of their fields within the batch.")
+	generateLedgerPayload(g, opts, t.Tenant, "    ")
+	g.P("    batch.Queue(", quotedQuery(d, outboxInsert), ", ", sqlArgs(t, key, "payload", "now", "now"), ", ", sqlArgs(t, key, "names"), ")")
+	g.P()
+	g.P("    return ", pgxstorePackage.Ident("SendBatch"), "(ctx, ", db, ", batch, func(results pgx.BatchResults) error {")
+	first := 0
+	if pii != "" {
+		first = 1
+		g.P("      if _, err := results.Exec(); err != nil {")
+		g.P("        return err")
+		g.P("      }")
+	}
+	g.P("      for i := ", first, "; i < batch.Len(); i++ {")
+	g.P("        if _, err := results.Exec(); err != nil {")
+	g.P("          return ", storePackage.Ident("VersionConflict"), "(err)")
+	g.P("        }")
+	g.P("      }")
+	g.P("      return nil")
+	g.P("    })")
+}
+
+func generatePgxSaveMany(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+	copyFrom := func(table string, columns []string, rows string) string {
+		return fmt.Sprintf("tx.CopyFrom(ctx, pgx.Identifier{%q}, %s, pgx.CopyFromRows(%s))", table, goStrings(columns), rows)
+	}
+
+	g.P("// SaveMany saves models as Save does, copying their PII rows, chain rows and")
+	g.P("// outbox entries with COPY in one transaction, for bulk imports. None of them")
+	g.P("// is saved if one fails.")
+	g.P("func (r *", modelName, "Repo) SaveMany(ctx context.Context, models []*", modelName, ") error {")
+	g.P("  if len(models) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {")
+	g.P("    return r.copyMany(ctx, tx, ", sqlArgs(t, "models"), ")")
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("// SaveBatch saves models as SaveMany does, copying them the batch size of the")
+	g.P("// repository at a time in one transaction.")
+	g.P("func (r *", modelName, "Repo) SaveBatch(ctx context.Context, models []*", modelName, ") error {")
+	g.P("  if len(models) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {")
+	g.P("    for _, batch := range ", storePackage.Ident("Batches"), "(models, r.batchSize) {")
+	g.P("      if err := r.copyMany(ctx, tx, ", sqlArgs(t, "batch"), "); err != nil {")
+	g.P("        return err")
+	g.P("      }")
+	g.P("    }")
+	g.P("    return nil")
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("// copyMany copies the PII rows, chain rows and outbox entries of models in tx.")
+	if t.Tenant {
+		g.P("func (r *", modelName, "Repo) copyMany(ctx context.Context, tx pgx.Tx, tenantID string, models []*", modelName, ") error {")
+	} else {
+		g.P("func (r *", modelName, "Repo) copyMany(ctx context.Context, tx pgx.Tx, models []*", modelName, ") error {")
+	}
+	g.P("  keys := make([]string, len(models))")
+	g.P("  for i, model := range models {")
+	g.P("    keys[i] = model.", columns.pkField)
+	g.P("  }")
+	g.P("  latest, err := r.latestVersions(ctx, tx, ", sqlArgs(t, "keys"), ")")
+	g.P("  if err != nil { return err }")
+	generateSaveRows(g, t, columns, opts)
+	g.P()
+	g.P("  if _, err := ", copyFrom(t.PiiTable(), columns.pii, "piiRows"), "; err != nil { return err }")
+	g.P("  if _, err := ", copyFrom(t.ChainTable(), columns.chain, "chainRows"), "; err != nil {")
+	g.P("    return ", storePackage.Ident("VersionConflict"), "(err)")
+	g.P("  }")
+	g.P("  _, err = ", copyFrom(t.OutboxTable(), columns.outbox, "outboxRows"))
+	g.P("  return err")
+	g.P("}")
+	g.P()
+}
+
+func generatePgxLatestVersions(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	db := pgxstorePackage.Ident("DB")
+	cond := d.Quote("key") + " = ANY(?)"
+	if t.Tenant {
+		cond = sqlCondition(d, t) + " AND " + cond
+	}
+	query := fmt.Sprintf("SELECT %s, field_name, MAX(version) FROM %s WHERE %s GROUP BY %s, field_name", d.Quote("key"), t.ChainTable(), cond, d.Quote("key"))
+
+	g.P("// latestVersions returns the latest chain version of every field of the records")
+	g.P("// of keys, by key.")
+	if t.Tenant {
+		g.P("func (r *", modelName, "Repo) latestVersions(ctx context.Context, q ", db, ", tenantID string, keys []string) (map[string]map[string]int64, error) {")
+	} else {
+		g.P("func (r *", modelName, "Repo) latestVersions(ctx context.Context, q ", db, ", keys []string) (map[string]map[string]int64, error) {")
+	}
+	g.P("  rows, err := q.Query(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, "keys"), ")")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  latest := make(map[string]map[string]int64, len(keys))")
+	g.P("  for rows.Next() {")
+	g.P("    var key, name string")
+	g.P("    var version int64")
+	g.P("    if err := rows.Scan(&key, &name, &version); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    if latest[key] == nil {")
+	g.P("      latest[key] = make(map[string]int64)")
+	g.P("    }")
+	g.P("    latest[key][name] = version")
+	g.P("  }")
+	g.P("  return latest, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+func generatePgxFetch(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	nullable := storePackage.Ident("Nullable")
+	query, dests := selectViewQuery(msg, d, t)
+
+	g.P("// Fetch returns the view of the record, or pgx.ErrNoRows.")
+	g.P("func (r *", modelName, "Repo) Fetch(ctx context.Context, id string) (*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  var view ", modelName, "View")
+	g.P("  // Chain fields without rows, and the tx_hash of unconfirmed records, are NULL.")
+	g.P("  err := r.db.QueryRow(ctx, ", quotedQuery(d, query+" WHERE "+sqlCondition(d, t, recordKeyName(msg))), ", ", sqlArgs(t, "id"), ").Scan(")
+	for _, dest := range dests {
+		g.P("    ", nullable, "(", dest, "),")
+	}
+	g.P("  )")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &view, nil")
+	g.P("}")
+	g.P()
+
+	cond := d.Quote(recordKeyName(msg)) + " = ANY(?)"
+	if t.Tenant {
+		cond = sqlCondition(d, t) + " AND " + cond
+	}
+	g.P("// FetchMany returns the views of the records of ids by id, reading them the")
+	g.P("// batch size of the repository at a time. Missing records are left out.")
+	g.P("func (r *", modelName, "Repo) FetchMany(ctx context.Context, ids []string) (map[string]*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  views := make(map[string]*", modelName, "View, len(ids))")
+	g.P("  for _, batch := range ", storePackage.Ident("Batches"), "(ids, r.batchSize) {")
+	g.P("    rows, err := r.queryViews(ctx, ", quotedQuery(d, query+" WHERE "+cond), ", ", sqlArgs(t, "batch"), ")")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    for i := range rows {")
+	g.P("      views[rows[i].", recordKeyField(msg), "] = &rows[i]")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return views, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// queryViews returns the views query returns.")
+	g.P("func (r *", modelName, "Repo) queryViews(ctx context.Context, query string, args ...any) ([]", modelName, "View, error) {")
+	g.P("  rows, err := r.db.Query(ctx, query, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var views []", modelName, "View")
+	g.P("  for rows.Next() {")
+	g.P("    var view ", modelName, "View")
+	g.P("    err := rows.Scan(")
+	for _, dest := range dests {
+		g.P("      ", nullable, "(", dest, "),")
+	}
+	g.P("    )")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    views = append(views, view)")
+	g.P("  }")
+	g.P("  return views, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+func generatePgxChainWriteMethods(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	status := ledgerPackage.Ident("Status")
+	columns, fields := sqlChainColumns(d, t)
+
+	g.P("// PendingChainWrites returns the chain rows of the record that are not confirmed on the ledger yet.")
+	g.P("func (r *", modelName, "Repo) PendingChainWrites(ctx context.Context, key string) ([]", modelName, "Chain, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  rows, err := r.db.Query(ctx, ", quotedQuery(d, pendingChainQuery(d, t, columns)), ", ", sqlArgs(t, "key", g.QualifiedGoIdent(ledgerPackage.Ident("StatusConfirmed"))), ")")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var chain []", modelName, "Chain")
+	g.P("  for rows.Next() {")
+	g.P("    var row ", modelName, "Chain")
+	g.P("    err := rows.Scan(")
+	for _, field := range fields {
+		g.P("      ", storePackage.Ident("Nullable"), "(&row.", field, "),")
+	}
+	g.P("    )")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    chain = append(chain, row)")
+	g.P("  }")
+	g.P("  return chain, rows.Err()")
+	g.P("}")
+	g.P()
+
+	update := fmt.Sprintf("UPDATE %s SET status = ?, tx_hash = COALESCE(NULLIF(?, ''), tx_hash) WHERE %s AND (field_name, version) IN (SELECT * FROM unnest(?::text[], ?::bigint[]))", t.ChainTable(), sqlCondition(d, t, "key"))
+	generateMarkChainWriteDoc(g)
+	g.P("func (r *", modelName, "Repo) MarkChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", status, ", txHash string) error {")
+	g.P("  if !status.Valid() {")
+	g.P("    return fmt.Errorf(\"invalid chain status %q\", status)")
+	g.P("  }")
+	g.P("  if len(writes) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  fields := make([]string, len(writes))")
+	g.P("  versions := make([]int64, len(writes))")
+	g.P("  for i, w := range writes {")
+	g.P("    fields[i], versions[i] = w.Field, w.Version")
+	g.P("  }")
+	g.P("  tag, err := r.db.Exec(ctx, ", quotedQuery(d, update), ", ", strings.Join([]string{"status", "txHash", sqlArgs(t, "key"), "fields", "versions"}, ", "), ")")
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  if tag.RowsAffected() == 0 {")
+	g.P("    return fmt.Errorf(\"no chain rows for key %q at %v\", key, writes)")
+	g.P("  }")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	generateConfirmChainWrite(g, msg, modelName+"Repo")
+
+	g.P("// CompactChain deletes the chain rows created before the given time that are")
+	g.P("// confirmed on the ledger and superseded: the latest keepVersions versions up to")
+	g.P("// the latest confirmed version of every field are kept, as are the rows not")
+	g.P("// confirmed yet. It spans every record, and every tenant, and returns the")
+	g.P("// number of rows deleted. Pruned versions can no longer be verified.")
+	g.P("func (r *", modelName, "Repo) CompactChain(ctx context.Context, before ", timePackage.Ident("Time"), ", keepVersions int) (int64, error) {")
+	g.P("  if keepVersions < 1 {")
+	g.P("    return 0, fmt.Errorf(\"compacting chain: keepVersions must be at least 1, got %d\", keepVersions)")
+	g.P("  }")
+	g.P("  tag, err := r.db.Exec(ctx, ", quotedQuery(d, CompactChainQuery(d, t)), ", before, keepVersions)")
+	g.P("  if err != nil {")
+	g.P("    return 0, err")
+	g.P("  }")
+	g.P("  return tag.RowsAffected(), nil")
+	g.P("}")
+	g.P()
+}
+
+func generatePgxMerkleRoot(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+
+	g.P("// MerkleRoot returns the latest Merkle root committed for the record.")
+	g.P("func (r *", modelName, "Repo) MerkleRoot(ctx context.Context, key string) (string, error) {")
+	generateRequireTenant(g, t.Tenant, `""`)
+	g.P("  var root string")
+	g.P("  err := r.db.QueryRow(ctx, ", quotedQuery(d, merkleRootQuery(d, t)), ", ", sqlArgs(t, "key", g.QualifiedGoIdent(merklePackage.Ident("RootField"))), ").Scan(", storePackage.Ident("Nullable"), "(&root))")
+	g.P("  if err != nil {")
+	g.P("    return \"\", err")
+	g.P("  }")
+	g.P("  return root, nil")
+	g.P("}")
+	g.P()
+}
+
+func generatePgxPublishedHashes(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	hashed := hashedFields(msg)
+
+	g.P("// publishedHashes returns the latest published hash of every hashed field of")
+	g.P("// the record, keyed by chain field name.")
+	g.P("func (r *", modelName, "Repo) publishedHashes(ctx context.Context, key string) (map[string]string, error) {")
+	if len(hashed) == 0 {
+		g.P("  return map[string]string{}, nil")
+		g.P("}")
+		g.P()
+		return
+	}
+	generateRequireTenant(g, t.Tenant, "nil")
+	query, names := publishedHashesQuery(d, t, hashed)
+	g.P("  rows, err := r.db.Query(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, append([]string{"key"}, names...)...), ")")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  published := make(map[string]string, ", len(hashed), ")")
+	g.P("  for rows.Next() {")
+	g.P("    var name, value string")
+	g.P("    if err := rows.Scan(&name, ", storePackage.Ident("Nullable"), "(&value)); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    if _, ok := published[name]; !ok {")
+	g.P("      published[name] = value")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return published, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+// generatePgxOutboxStore generates the outbox.Store implementation of a
+// message on pgx.
+func generatePgxOutboxStore(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	entry := outboxPackage.Ident("Entry")
+	now := timePackage.Ident("Time")
+	pending := g.QualifiedGoIdent(outboxPackage.Ident("StatusPending"))
+	db := pgxstorePackage.Ident("DB")
+	update := func(set string) string {
+		return quotedQuery(d, outboxUpdate(t, set))
+	}
+
+	g.P("// ", modelName, "Outbox is the ", outboxPackage.Ident("Store"), " of ", modelName, " chain writes.")
+	g.P("type ", modelName, "Outbox struct {")
+	g.P("  db ", db)
+	g.P("}")
+	g.P()
+	g.P("var _ ", outboxPackage.Ident("Store"), " = (*", modelName, "Outbox)(nil)")
+	g.P()
+	g.P("func New", modelName, "Outbox(db ", db, ") *", modelName, "Outbox {")
+	g.P("  return &", modelName, "Outbox{db: db}")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) Pending(ctx context.Context, now ", now, ", limit int) ([]", entry, ", error) {")
+	g.P("  rows, err := o.db.Query(ctx, ", quotedQuery(d, outboxPendingQuery(d, t)), ", ", pending, ", now, limit)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var entries []", entry)
+	g.P("  for rows.Next() {")
+	g.P("    var e ", entry)
+	g.P("    if err := rows.Scan(", outboxEntryScan(t), "); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    entries = append(entries, e)")
+	g.P("  }")
+	g.P("  return entries, rows.Err()")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkDelivered(ctx context.Context, id int64, txHash string) error {")
+	g.P("  return pgx.BeginFunc(ctx, o.db, func(tx pgx.Tx) error {")
+	g.P("    row, err := o.finish(ctx, tx, id, ", update("status = ?, tx_hash = ?, delivered_at = ?"), ",")
+	g.P("      ", outboxPackage.Ident("StatusDelivered"), ", txHash, ", timePackage.Ident("Now"), "(), id, ", pending, ")")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(ctx, tx, row, ", ledgerPackage.Ident("StatusConfirmed"), ", txHash)")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkRetry(ctx context.Context, id int64, cause error, next ", now, ") error {")
+	g.P("  _, err := o.finish(ctx, o.db, id, ", update("attempts = attempts + 1, last_error = ?, next_attempt_at = ?"), ",")
+	g.P("    cause.Error(), next, id, ", pending, ")")
+	g.P("  return err")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkFailed(ctx context.Context, id int64, cause error) error {")
+	g.P("  return pgx.BeginFunc(ctx, o.db, func(tx pgx.Tx) error {")
+	g.P("    row, err := o.finish(ctx, tx, id, ", update("status = ?, attempts = attempts + 1, last_error = ?"), ",")
+	g.P("      ", outboxPackage.Ident("StatusFailed"), ", cause.Error(), id, ", pending, ")")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(ctx, tx, row, ", ledgerPackage.Ident("StatusFailed"), ", \"\")")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	query, dests := outboxEntryQuery(d, t)
+	g.P("// finish applies update, an UPDATE of the pending outbox entry id, and returns the entry.")
+	g.P("func (o *", modelName, "Outbox) finish(ctx context.Context, q ", db, ", id int64, update string, args ...any) (*", modelName, "OutboxEntry, error) {")
+	g.P("  tag, err := q.Exec(ctx, update, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  if tag.RowsAffected() == 0 {")
+	g.P("    return nil, fmt.Errorf(\"no pending outbox entry %d\", id)")
+	g.P("  }")
+	g.P("  row := ", modelName, "OutboxEntry{ID: id}")
+	g.P("  if err := q.QueryRow(ctx, ", quotedQuery(d, query), ", id).Scan(", strings.Join(dests, ", "), "); err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &row, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// markChain moves the chain rows carried by an outbox entry to status.")
+	g.P("func (o *", modelName, "Outbox) markChain(ctx context.Context, tx pgx.Tx, row *", modelName, "OutboxEntry, status ", ledgerPackage.Ident("Status"), ", txHash string) error {")
+	g.P("  var writes []", ledgerPackage.Ident("FieldVersion"), "")
+	g.P("  if err := ", jsonPackage.Ident("Unmarshal"), "([]byte(row.Versions), &writes); err != nil {")
+	g.P("    return fmt.Errorf(\"outbox entry %d: decoding versions: %w\", row.ID, err)")
+	g.P("  }")
+	if t.Tenant {
+		// The dispatcher serves every tenant; the entry names the one of the rows.
+		g.P("  ctx = ", tenantPackage.Ident("With"), "(ctx, row.TenantID)")
+	}
+	g.P("  return New", modelName, "Repo(tx).MarkChainWrite(ctx, row.Key, writes, status, txHash)")
+	g.P("}")
+	g.P()
+}
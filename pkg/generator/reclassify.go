@@ -0,0 +1,145 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReclassificationTable records the fields whose classification migrations
+// changed.
+const ReclassificationTable = "sdm_reclassifications"
+
+// Actions taken on the data of a reclassified field.
+const (
+	// ActionCopyPurge copies the latest chain value of a field into the PII
+	// table and deletes its chain history.
+	ActionCopyPurge = "copy-purge"
+	// ActionCopyHash copies the latest chain value of a field into the PII
+	// table and replaces its chain history with hashes.
+	ActionCopyHash = "copy-hash"
+	// ActionCopyToChain appends the PII value of a field to the chain table.
+	ActionCopyToChain = "copy-to-chain"
+	// ActionHashOnSave leaves the data as is; the hash of a newly hashed
+	// field is published with the next save of each record.
+	ActionHashOnSave = "hash-on-save"
+	// ActionKeep leaves the data as is.
+	ActionKeep = "keep"
+)
+
+// Reclassification is a change of the classification of a field and of the
+// data migration it takes.
+type Reclassification struct {
+	Message string
+	Field   string
+	From    string
+	To      string
+	Action  string
+	// CountQuery counts the rows the data migration affects.
+	CountQuery string
+	// Fallback, when set, explains why Action falls short of what the
+	// classifications call for in the dialect of the migration.
+	Fallback string
+}
+
+func (r Reclassification) String() string {
+	return fmt.Sprintf("%s.%s: %s -> %s (%s)", r.Message, r.Field, r.From, r.To, r.Action)
+}
+
+// Class returns the classification of the field: where it is stored, and
+// whether its hash is published.
+func (f TableField) Class() string {
+	class := "chain"
+	if f.InPii() {
+		class = "pii"
+	}
+	if f.Hashed {
+		class += "+hashed"
+	}
+	return class
+}
+
+// Reclassified reports whether from and to classify a field differently.
+func Reclassified(from, to TableField) bool {
+	return from.Class() != to.Class()
+}
+
+// CreateReclassificationTable returns the statement creating the table
+// recording reclassifications.
+func CreateReclassificationTable(d Dialect) string {
+	lines := []string{
+		"  " + d.SerialPrimaryKey("id"),
+		"  message " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  field_name " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  from_class " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  to_class " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  action " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  migrated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	}
+	return "CREATE TABLE IF NOT EXISTS " + ReclassificationTable + " (\n" + strings.Join(lines, ",\n") + "\n)"
+}
+
+// reclassify returns the statements migrating the data of a field of t from
+// one classification to another, and the reclassification they record. The
+// PII column of the field must exist when they run.
+func reclassify(d Dialect, t Table, from, to TableField) ([]string, Reclassification) {
+	r := Reclassification{Message: t.Message, Field: to.Name, From: from.Class(), To: to.Class()}
+	chain := t.ChainTable()
+	pii := t.PiiTable()
+	column := d.Quote(to.Name)
+	key := d.Quote("key")
+	pk := keyColumn(d, t)
+	tenant := d.Quote(TenantColumn)
+
+	var stmts []string
+	switch {
+	case !from.InPii() && to.InPii():
+		r.CountQuery = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE field_name = '%s'", chain, to.Name)
+		match := fmt.Sprintf("c.%s = %s", key, d.Cast(pii+"."+pk, ColumnText))
+		if t.Tenant {
+			match += fmt.Sprintf(" AND c.%s = %s.%s", tenant, pii, tenant)
+		}
+		stmts = append(stmts, fmt.Sprintf(
+			"UPDATE %s SET %s = (SELECT %s FROM %s c WHERE %s AND c.field_name = '%s' ORDER BY c.version DESC LIMIT 1)",
+			pii, column, d.Cast("c.field_value", to.Type), chain, match, to.Name))
+		// The plaintext must not stay in the chain table. If the field now
+		// publishes its hash, its history becomes the history of the hash,
+		// unless that exists already.
+		hash, ok := d.Hash("field_value")
+		if to.Hashed && !from.Hashed && ok {
+			r.Action = ActionCopyHash
+			stmts = append(stmts, fmt.Sprintf("UPDATE %s SET field_name = 'hashed_%s', field_value = %s WHERE field_name = '%s'", chain, to.Name, hash, to.Name))
+		} else {
+			if to.Hashed && !from.Hashed {
+				r.Fallback = fmt.Sprintf("%s has no SHA-256, so the chain history of %s is deleted instead of hashed; its hash is published from the next save of each record", d.Name(), to.Name)
+			}
+			r.Action = ActionCopyPurge
+			stmts = append(stmts, fmt.Sprintf("DELETE FROM %s WHERE field_name = '%s'", chain, to.Name))
+		}
+	case from.InPii() && !to.InPii():
+		// The copies are pending: they are published with the next save of
+		// each record.
+		r.Action = ActionCopyToChain
+		r.CountQuery = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s IS NOT NULL", pii, column)
+		keys, values := key, d.Cast("p."+pk, ColumnText)
+		match := fmt.Sprintf("c.%s = %s", key, d.Cast("p."+pk, ColumnText))
+		if t.Tenant {
+			keys = tenant + ", " + keys
+			values = "p." + tenant + ", " + values
+			match += fmt.Sprintf(" AND c.%s = p.%s", tenant, tenant)
+		}
+		stmts = append(stmts, fmt.Sprintf(
+			"INSERT INTO %s (%s, field_name, version, field_value) SELECT %s, '%s', COALESCE((SELECT MAX(c.version) FROM %s c WHERE %s AND c.field_name = '%s'), 0) + 1, %s FROM %s p WHERE p.%s IS NOT NULL",
+			chain, keys, values, to.Name, chain, match, to.Name, d.Cast("p."+column, ColumnText), pii, column))
+	case to.Hashed && !from.Hashed:
+		r.Action = ActionHashOnSave
+		r.CountQuery = "SELECT COUNT(*) FROM " + pii
+	default:
+		r.Action = ActionKeep
+		r.CountQuery = "SELECT 0"
+	}
+
+	stmts = append(stmts, fmt.Sprintf(
+		"INSERT INTO %s (message, field_name, from_class, to_class, action) VALUES ('%s', '%s', '%s', '%s', '%s')",
+		ReclassificationTable, r.Message, r.Field, r.From, r.To, r.Action))
+	return stmts, r
+}
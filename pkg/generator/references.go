@@ -0,0 +1,269 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// referencedMessage returns the message of the file of field that its
+// (sdm.references) option names, or nil without one.
+func referencedMessage(field protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	name := FieldOptions(field).References
+	if name == "" {
+		return nil
+	}
+	file := field.ParentFile()
+	name = strings.TrimPrefix(name, string(file.Package())+".")
+	return file.Messages().ByName(protoreflect.Name(name))
+}
+
+// singlePrimaryKey returns the primary key field of msg, or nil unless it has
+// exactly one.
+func singlePrimaryKey(msg protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	var pk protoreflect.FieldDescriptor
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if FieldOptions(fields.Get(i)).PrimaryKey {
+			if pk != nil {
+				return nil
+			}
+			pk = fields.Get(i)
+		}
+	}
+	return pk
+}
+
+// ValidateReferences reports an error for a (sdm.references) option of a
+// message of file that no foreign key can be generated for.
+func ValidateReferences(file *protogen.File) error {
+	for _, msg := range file.Messages {
+		children := map[string]bool{}
+		for _, field := range msg.Fields {
+			opts := getFieldOptions(field)
+			if opts.References == "" {
+				continue
+			}
+			name := fmt.Sprintf("%s.%s", msg.Desc.Name(), field.Desc.Name())
+			ref := referencedMessage(field.Desc)
+			if ref == nil {
+				return fmt.Errorf("%s: references unknown message %q; it must be a message of %s", name, opts.References, file.Desc.Path())
+			}
+			if !opts.PrimaryKey && !opts.QueryIndex {
+				return fmt.Errorf("%s: a field with references must be a primary_key or query_index field", name)
+			}
+			pk := singlePrimaryKey(ref)
+			if pk == nil {
+				return fmt.Errorf("%s: references %s, which does not have exactly one primary_key field", name, ref.Name())
+			}
+			if columnTypeForKind(field.Desc.Kind()) != columnTypeForKind(pk.Kind()) {
+				return fmt.Errorf("%s: references %s.%s of another type", name, ref.Name(), pk.Name())
+			}
+			if children[string(ref.Name())] {
+				return fmt.Errorf("%s: %s references %s more than once", name, msg.Desc.Name(), ref.Name())
+			}
+			children[string(ref.Name())] = true
+		}
+	}
+	for _, msg := range file.Messages {
+		for _, a := range associations(file, msg) {
+			for _, field := range msg.Fields {
+				if field.GoName == a.Name || "Hashed"+field.GoName == a.Name {
+					return fmt.Errorf("%s: association %s clashes with field %s", msg.Desc.Name(), a.Name, field.Desc.Name())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// association is a GORM association of the Pii and View structs of a
+// message, over a field with references.
+type association struct {
+	// Name is the name of the association field.
+	Name string
+	// Message is the associated message.
+	Message *protogen.Message
+	// Many is set for the has-many association of the referenced message,
+	// and unset for the belongs-to association of the referencing one.
+	Many bool
+	// ForeignKey is the referencing field, and References the primary key
+	// it holds.
+	ForeignKey, References *protogen.Field
+}
+
+// associations returns the associations of msg, a message of file: a
+// belongs-to association for each of its fields with references, named after
+// the field without its Id suffix, and a has-many association for each
+// message referencing it, named after its plural.
+func associations(file *protogen.File, msg *protogen.Message) []association {
+	var as []association
+	for _, field := range msg.Fields {
+		ref := referencedMessage(field.Desc)
+		if ref == nil {
+			continue
+		}
+		parent := fileMessage(file, ref)
+		name := strings.TrimSuffix(field.GoName, "Id")
+		if name == "" || name == field.GoName {
+			name = parent.GoIdent.GoName
+		}
+		as = append(as, association{Name: name, Message: parent, ForeignKey: field, References: primaryKeyField(parent)})
+	}
+	for _, child := range file.Messages {
+		for _, field := range child.Fields {
+			if ref := referencedMessage(field.Desc); ref != nil && ref.FullName() == msg.Desc.FullName() {
+				as = append(as, association{Name: child.GoIdent.GoName + "s", Message: child, Many: true, ForeignKey: field, References: primaryKeyField(msg)})
+			}
+		}
+	}
+	return as
+}
+
+// fileMessage returns the message of file described by desc.
+func fileMessage(file *protogen.File, desc protoreflect.MessageDescriptor) *protogen.Message {
+	for _, msg := range file.Messages {
+		if msg.Desc.FullName() == desc.FullName() {
+			return msg
+		}
+	}
+	return nil
+}
+
+// generateAssociations generates the association fields of the <suffix>
+// struct of msg, Pii or View.
+func generateAssociations(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, suffix string, tenant bool) {
+	for _, a := range associations(file, msg) {
+		foreignKey, references := a.ForeignKey.GoName, a.References.GoName
+		if tenant {
+			foreignKey, references = "TenantID,"+foreignKey, "TenantID,"+references
+		}
+		typ := "*" + a.Message.GoIdent.GoName + suffix
+		if a.Many {
+			typ = "[]" + a.Message.GoIdent.GoName + suffix
+		}
+		g.P(a.Name, " ", typ, " `gorm:\"foreignKey:", foreignKey, ";references:", references, "\"`")
+	}
+}
+
+// OrderByReferences returns tables ordered so that every table follows the
+// tables it references, and otherwise in their order.
+func OrderByReferences(tables []Table) []Table {
+	byTable := make(map[string]Table, len(tables))
+	for _, t := range tables {
+		byTable[t.PiiTable()] = t
+	}
+	done := make(map[string]bool, len(tables))
+	var ordered []Table
+	var visit func(t Table)
+	visit = func(t Table) {
+		if done[t.PiiTable()] {
+			return
+		}
+		done[t.PiiTable()] = true
+		for _, f := range t.Fields {
+			if ref, ok := byTable[f.References]; ok && f.InPii() {
+				visit(ref)
+			}
+		}
+		ordered = append(ordered, t)
+	}
+	for _, t := range tables {
+		visit(t)
+	}
+	return ordered
+}
+
+// goCamelCase returns the Go name protogen gives a top-level message called
+// name: an initial underscore becomes X, and other underscores followed by a
+// lower case letter are dropped with the letter upper-cased.
+func goCamelCase(name string) string {
+	var b []byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_' && i == 0:
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(name) && 'a' <= name[i+1] && name[i+1] <= 'z':
+		case 'a' <= c && c <= 'z' && (i == 0 || name[i-1] == '_' || '0' <= name[i-1] && name[i-1] <= '9'):
+			b = append(b, c-'a'+'A')
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// manyAssociations returns the has-many associations of msg.
+func manyAssociations(file *protogen.File, msg *protogen.Message) []association {
+	var many []association
+	for _, a := range associations(file, msg) {
+		if a.Many {
+			many = append(many, a)
+		}
+	}
+	return many
+}
+
+// generateGormFetchWith generates the FetchWith<Children> of the GORM
+// repository of msg for each message referencing it, preloading the
+// association.
+func generateGormFetchWith(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	for _, a := range manyAssociations(file, msg) {
+		g.P("// FetchWith", a.Name, " returns the view of the record with its ", a.Name, ", ordered by")
+		g.P("// primary key, or gorm.ErrRecordNotFound.")
+		g.P("func (r *", modelName, "Repo) FetchWith", a.Name, "(ctx context.Context, id string) (*", modelName, "View, error) {")
+		generateRequireTenant(g, tenant, "nil")
+		g.P("  var view ", modelName, "View")
+		g.P("  err := r.db.WithContext(ctx).")
+		g.P("    Preload(", fmt.Sprintf("%q", a.Name), ", func(db *gorm.DB) *gorm.DB { return db.Order(", fmt.Sprintf("%q", recordKeyName(a.Message)), ") }).")
+		g.P("    Where(", scoped(tenant, fmt.Sprintf("%q: id", recordKeyName(msg))), ").First(&view).Error")
+		g.P("  if err != nil {")
+		g.P("    return nil, err")
+		g.P("  }")
+		g.P("  return &view, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+// generateSQLFetchWith generates the FetchWith<Children> of the database/sql
+// or pgx repository of msg for each message referencing it, reading the
+// views of the children with the queryViews of their repository.
+func generateSQLFetchWith(g *protogen.GeneratedFile, file *protogen.File, msg *protogen.Message, d Dialect, opts Options) {
+	modelName := msg.GoIdent.GoName
+	notFound := "sql.ErrNoRows"
+	if opts.Backend == BackendPgx {
+		notFound = "pgx.ErrNoRows"
+	}
+	for _, a := range manyAssociations(file, msg) {
+		child := a.Message.GoIdent.GoName
+		t := NewTable(a.Message, opts)
+		query, _ := selectViewQuery(a.Message, d, t)
+		query += " WHERE " + sqlCondition(d, t, string(a.ForeignKey.Desc.Name())) + " ORDER BY " + d.Quote(recordKeyName(a.Message))
+
+		g.P("// FetchWith", a.Name, " returns the view of the record with its ", a.Name, ", ordered by")
+		g.P("// primary key, or ", notFound, ".")
+		g.P("func (r *", modelName, "Repo) FetchWith", a.Name, "(ctx context.Context, id string) (*", modelName, "View, error) {")
+		generateRequireTenant(g, t.Tenant, "nil")
+		g.P("  view, err := r.Fetch(ctx, id)")
+		g.P("  if err != nil {")
+		g.P("    return nil, err")
+		g.P("  }")
+		if opts.Backend == BackendPgx {
+			g.P("  children := &", child, "Repo{db: r.db}")
+		} else {
+			g.P("  children := &", child, "Repo{db: r.db, tx: r.tx}")
+		}
+		g.P("  view.", a.Name, ", err = children.queryViews(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, "view."+a.References.GoName), ")")
+		g.P("  if err != nil {")
+		g.P("    return nil, err")
+		g.P("  }")
+		g.P("  return view, nil")
+		g.P("}")
+		g.P()
+	}
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Table describes the relational layout generated for a message: its PII
+// table, chain table, outbox table and view.
+type Table struct {
+	// Message is the full name of the proto message.
+	Message string `json:"message"`
+	// Name is the lower-cased Go name of the message the table names derive from.
+	Name   string       `json:"name"`
+	Fields []TableField `json:"fields"`
+	// Tenant is set for multi-tenant messages, whose tables are keyed by
+	// tenant_id too.
+	Tenant bool `json:"tenant,omitempty"`
+	// ChainPartition is the partitioning of the chain table, see
+	// Options.ChainPartition.
+	ChainPartition string `json:"chain_partition,omitempty"`
+	// ChainPartitions is the number of partitions of a hash-partitioned
+	// chain table.
+	ChainPartitions int `json:"chain_partitions,omitempty"`
+	// RowVersion is set for PII tables with the row_version column Update
+	// checks; tables of older snapshots lack it.
+	RowVersion bool `json:"row_version,omitempty"`
+	// SoftDelete is set for soft-delete messages, whose PII table has a
+	// deleted_at column the view filters by.
+	SoftDelete bool `json:"soft_delete,omitempty"`
+}
+
+// RowVersionColumn is the column of the PII tables counting the writes of a
+// record, which Update checks to detect concurrent writers.
+const RowVersionColumn = "row_version"
+
+// TableField is a field of a message with its SDM classification.
+type TableField struct {
+	Name       string     `json:"name"`
+	Type       ColumnType `json:"type"`
+	PrimaryKey bool       `json:"primary_key,omitempty"`
+	Pii        bool       `json:"pii,omitempty"`
+	QueryIndex bool       `json:"query_index,omitempty"`
+	Hashed     bool       `json:"hashed,omitempty"`
+	// References is the PII table the field is a foreign key to, and
+	// ReferencedColumn its primary key.
+	References       string `json:"references,omitempty"`
+	ReferencedColumn string `json:"referenced_column,omitempty"`
+}
+
+// InPii reports whether the field is a column of the PII table.
+func (f TableField) InPii() bool {
+	return f.PrimaryKey || f.Pii || f.QueryIndex
+}
+
+// NewTable returns the table layout of msg generated with opts.
+func NewTable(msg *protogen.Message, opts Options) Table {
+	t := Table{
+		Message:         string(msg.Desc.FullName()),
+		Name:            strings.ToLower(msg.GoIdent.GoName),
+		Tenant:          multiTenant(msg, opts),
+		ChainPartition:  opts.ChainPartition,
+		ChainPartitions: opts.chainPartitions(),
+		RowVersion:      true,
+		SoftDelete:      softDelete(msg, opts),
+	}
+	for _, field := range msg.Fields {
+		opts := getFieldOptions(field)
+		tf := TableField{
+			Name:       string(field.Desc.Name()),
+			Type:       columnTypeForKind(field.Desc.Kind()),
+			PrimaryKey: opts.PrimaryKey,
+			Pii:        opts.Pii,
+			QueryIndex: opts.QueryIndex,
+			Hashed:     opts.Hashed,
+		}
+		if ref := referencedMessage(field.Desc); ref != nil {
+			if pk := singlePrimaryKey(ref); pk != nil {
+				tf.References = Table{Name: strings.ToLower(goCamelCase(string(ref.Name())))}.PiiTable()
+				tf.ReferencedColumn = string(pk.Name())
+			}
+		}
+		t.Fields = append(t.Fields, tf)
+	}
+	return t
+}
+
+// References returns the fields of t that are foreign keys.
+func (t Table) References() []TableField {
+	var refs []TableField
+	for _, f := range t.Fields {
+		if f.References != "" && f.InPii() {
+			refs = append(refs, f)
+		}
+	}
+	return refs
+}
+
+// ForeignKeyName returns the name of the foreign key constraint of field.
+func (t Table) ForeignKeyName(field string) string { return t.PiiTable() + "_" + field + "_fkey" }
+
+func (t Table) PiiTable() string    { return "pii_" + t.Name + "s" }
+func (t Table) ChainTable() string  { return "chain_" + t.Name + "s" }
+func (t Table) OutboxTable() string { return "outbox_" + t.Name + "s" }
+func (t Table) View() string        { return t.Name + "s" }
+
+// RedactedView returns the name of the view masking the PII columns.
+func (t Table) RedactedView() string { return t.Name + "s_redacted" }
+
+// CompactIndex returns the name of the index of the chain table that
+// CompactChain scans.
+func (t Table) CompactIndex() string { return t.ChainTable() + "_compact" }
+
+// IndexName returns the name of the index of the PII column of field.
+func (t Table) IndexName(field string) string { return t.PiiTable() + "_" + field }
+
+// PrimaryKey returns the names of the primary key fields.
+func (t Table) PrimaryKey() []string {
+	var pk []string
+	for _, f := range t.Fields {
+		if f.PrimaryKey {
+			pk = append(pk, f.Name)
+		}
+	}
+	return pk
+}
+
+func columnTypeForKind(kind protoreflect.Kind) ColumnType {
+	switch kind {
+	case protoreflect.Int64Kind, protoreflect.Int32Kind:
+		return ColumnInteger
+	default:
+		return ColumnText
+	}
+}
@@ -0,0 +1,384 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	sdm "github.com/jinuthankachan/sdm/sdmprotos"
+)
+
+// DeletedAtColumn is the column of the PII tables of soft-delete messages
+// holding the time a record was deleted at, NULL while it is not.
+const DeletedAtColumn = "deleted_at"
+
+// softDelete reports whether the records of msg are soft deleted.
+func softDelete(msg *protogen.Message, opts Options) bool {
+	return opts.SoftDelete || FileSoftDelete(msg.Desc.ParentFile())
+}
+
+// FileSoftDelete reports whether file is annotated with (sdm.soft_delete).
+func FileSoftDelete(file protoreflect.FileDescriptor) bool {
+	opts := file.Options()
+	if opts == nil || !proto.HasExtension(opts, sdm.E_SoftDelete) {
+		return false
+	}
+	v, _ := proto.GetExtension(opts, sdm.E_SoftDelete).(bool)
+	return v
+}
+
+// deletedAtColumn returns the deleted_at column, null for live rows.
+func deletedAtColumn(d Dialect) Column {
+	return Column{Name: DeletedAtColumn, Type: "TIMESTAMP"}
+}
+
+func deletedAtColumnDefinition(d Dialect) string {
+	return columnDefinition(d, deletedAtColumn(d))
+}
+
+// ValidateSoftDelete reports an error for a field of a soft-delete message of
+// file whose column would clash with the deleted_at column of its PII table.
+func ValidateSoftDelete(file *protogen.File, opts Options) error {
+	for _, msg := range file.Messages {
+		if !softDelete(msg, opts) {
+			continue
+		}
+		for _, field := range msg.Fields {
+			if string(field.Desc.Name()) == DeletedAtColumn || field.GoName == "DeletedAt" {
+				return fmt.Errorf("%s.%s: the field name is reserved for the deletion time of the record", msg.Desc.Name(), field.Desc.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// softDeleteQuery returns the UPDATE of the PII row of msg marking it deleted
+// at a ? placeholder, or restoring it, bumping its row version. It matches
+// no row unless the record is, respectively, not deleted or deleted.
+func softDeleteQuery(msg *protogen.Message, d Dialect, t Table, restore bool) string {
+	column := d.Quote(DeletedAtColumn)
+	set, cond := column+" = ?", column+" IS NULL"
+	if restore {
+		set, cond = column+" = NULL", column+" IS NOT NULL"
+	}
+	version := d.Quote(RowVersionColumn)
+	return fmt.Sprintf("UPDATE %s SET %s, %s = %s + 1 WHERE %s AND %s", t.PiiTable(), set, version, version, sqlCondition(d, t, recordKeyName(msg)), cond)
+}
+
+// includingDeletedTable returns the derived table of the view of t with its
+// deleted records, which FetchIncludingDeleted selects from.
+func includingDeletedTable(d Dialect, t Table) string {
+	return "(" + IncludingDeletedQuery(d, t) + ") v"
+}
+
+// generateSoftDeleteDoc generates the doc comment of the Delete, or Restore,
+// of a repository returning errNotFound for a missing record.
+func generateSoftDeleteDoc(g *protogen.GeneratedFile, restore bool, errNotFound string) {
+	if restore {
+		g.P("// Restore brings back the deleted record of id, bumping its row version and")
+		g.P("// appending an empty ", ledgerPackage.Ident("TombstoneField"), " chain field for the ledger. It returns")
+		g.P("// ", errNotFound, " unless the record is deleted.")
+		return
+	}
+	g.P("// Delete marks the record of id deleted, bumping its row version and appending")
+	g.P("// the deletion time as its ", ledgerPackage.Ident("TombstoneField"), " chain field for the ledger. The record")
+	g.P("// and its chain rows are kept, but only FetchIncludingDeleted finds it until it")
+	g.P("// is restored. It returns ", errNotFound, " without a record to delete.")
+}
+
+// softDeleteMethod returns the name of the Delete, or Restore, of a
+// repository.
+func softDeleteMethod(restore bool) string {
+	if restore {
+		return "Restore"
+	}
+	return "Delete"
+}
+
+// generateTombstoneValue generates the deletion time of a Delete, as now, and
+// the value of its tombstone, or the empty tombstone of a Restore.
+func generateTombstoneValue(g *protogen.GeneratedFile, restore bool) {
+	if restore {
+		g.P("    value := \"\"")
+		return
+	}
+	g.P("    now := ", timePackage.Ident("Now"), "()")
+	g.P("    value := now.UTC().Format(", timePackage.Ident("RFC3339Nano"), ")")
+}
+
+// generateGormSoftDelete generates the GORM Delete, Restore and
+// FetchIncludingDeleted of msg, and the tombstone they write.
+func generateGormSoftDelete(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	key := fmt.Sprintf("%q: id", recordKeyName(msg))
+	tenantArg := ""
+	if t.Tenant {
+		tenantArg = "tenantID, "
+	}
+
+	for _, restore := range []bool{false, true} {
+		deletedAt, cond := "now", DeletedAtColumn+" IS NULL"
+		if restore {
+			deletedAt, cond = "nil", DeletedAtColumn+" IS NOT NULL"
+		}
+		generateSoftDeleteDoc(g, restore, "gorm.ErrRecordNotFound")
+		g.P("func (r *", modelName, "Repo) ", softDeleteMethod(restore), "(ctx context.Context, id string) error {")
+		generateRequireTenant(g, t.Tenant, "")
+		g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+		g.P("  return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+		generateTombstoneValue(g, restore)
+		g.P("    res := tx.Model(&", modelName, "Pii{}).Where(", scoped(t.Tenant, key), ").Where(", fmt.Sprintf("%q", cond), ").Updates(map[string]interface{}{")
+		g.P("      ", fmt.Sprintf("%q", DeletedAtColumn), ": ", deletedAt, ",")
+		g.P("      ", fmt.Sprintf("%q", RowVersionColumn), ": gorm.Expr(", fmt.Sprintf("%q", RowVersionColumn+" + 1"), "),")
+		g.P("    })")
+		g.P("    if res.Error != nil { return res.Error }")
+		g.P("    if res.RowsAffected == 0 { return gorm.ErrRecordNotFound }")
+		g.P("    return r.tombstone(tx, ", tenantArg, "id, value)")
+		g.P("  })")
+		g.P("  })")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("// tombstone appends value as the ", ledgerPackage.Ident("TombstoneField"), " chain field of the record of id")
+	g.P("// and queues it for the ledger.")
+	g.P("func (r *", modelName, "Repo) tombstone(tx *gorm.DB, ", tenantArg, "id, value string) error {")
+	if t.Tenant {
+		g.P("  latest, err := r.latestVersions(tx, tenantID, []string{id})")
+	} else {
+		g.P("  latest, err := r.latestVersions(tx, []string{id})")
+	}
+	g.P("  if err != nil { return err }")
+	g.P("  field := ", ledgerPackage.Ident("Field"), "{Name: ", ledgerPackage.Ident("TombstoneField"), ", Value: value}")
+	if t.Tenant {
+		g.P("  row := ", modelName, "Chain{TenantID: tenantID, Key: id, FieldName: field.Name, Version: latest[id][field.Name] + 1, FieldValue: field.Value}")
+	} else {
+		g.P("  row := ", modelName, "Chain{Key: id, FieldName: field.Name, Version: latest[id][field.Name] + 1, FieldValue: field.Value}")
+	}
+	g.P("  if err := tx.Create(&row).Error; err != nil { return ", storePackage.Ident("VersionConflict"), "(err) }")
+	generateTombstonePayload(g, msg, t.Tenant, "row.Version")
+	g.P("  return tx.Create(&", modelName, "OutboxEntry{")
+	if t.Tenant {
+		g.P("    TenantID: tenantID,")
+	}
+	g.P("    Key: id,")
+	g.P("    Versions: string(versionsJSON),")
+	g.P("    Payload: payload,")
+	g.P("    NextAttemptAt: ", timePackage.Ident("Now"), "(),")
+	g.P("  }).Error")
+	g.P("}")
+	g.P()
+
+	g.P("// FetchIncludingDeleted returns the view of the record whether it is deleted or")
+	g.P("// not, or gorm.ErrRecordNotFound.")
+	g.P("func (r *", modelName, "Repo) FetchIncludingDeleted(ctx context.Context, id string) (*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  var view ", modelName, "View")
+	g.P("  // The derived table is named after the view, which GORM qualifies columns with.")
+	g.P("  query := gorm.Expr(", fmt.Sprintf("%q", IncludingDeletedQuery(d, t)), ")")
+	g.P("  if err := r.db.WithContext(ctx).Table(", fmt.Sprintf("%q", "(?) AS "+t.View()), ", query).Where(", scoped(t.Tenant, key), ").First(&view).Error; err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &view, nil")
+	g.P("}")
+	g.P()
+}
+
+// generateTombstonePayload generates the ledger payload of the tombstone
+// field, and the JSON of the field and version of its row alone: the other
+// fields have rows at the same version that the tombstone does not carry.
+func generateTombstonePayload(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool, version string) {
+	g.P("  payload, err := ", ledgerPayload(g, msg, tenant, "id", "[]"+g.QualifiedGoIdent(ledgerPackage.Ident("Field"))+"{field}"))
+	g.P("  if err != nil { return err }")
+	g.P("  versionsJSON, err := ", jsonPackage.Ident("Marshal"), "([]", ledgerPackage.Ident("FieldVersion"), "{{Field: field.Name, Version: ", version, "}})")
+	g.P("  if err != nil { return err }")
+}
+
+// generateSQLSoftDelete generates the database/sql Delete, Restore and
+// FetchIncludingDeleted of msg, and the tombstone they write.
+func generateSQLSoftDelete(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+
+	for _, restore := range []bool{false, true} {
+		args := sqlArgs(t, "id")
+		if !restore {
+			args = "now, " + args
+		}
+		generateSoftDeleteDoc(g, restore, "sql.ErrNoRows")
+		g.P("func (r *", modelName, "Repo) ", softDeleteMethod(restore), "(ctx context.Context, id string) error {")
+		generateRequireTenant(g, t.Tenant, "")
+		g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+		g.P("  return ", storePackage.Ident("InTx"), "(ctx, r.db, r.tx, func(tx *sql.Tx) error {")
+		generateTombstoneValue(g, restore)
+		g.P("    res, err := tx.ExecContext(ctx, ", quotedQuery(d, softDeleteQuery(msg, d, t, restore)), ", ", args, ")")
+		g.P("    if err != nil { return err }")
+		g.P("    n, err := res.RowsAffected()")
+		g.P("    if err != nil { return err }")
+		g.P("    if n == 0 { return sql.ErrNoRows }")
+		g.P("    return r.tombstone(ctx, tx, ", sqlArgs(t, "id", "value"), ")")
+		g.P("  })")
+		g.P("  })")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("// tombstone appends value as the ", ledgerPackage.Ident("TombstoneField"), " chain field of the record of id")
+	g.P("// and queues it for the ledger.")
+	g.P("func (r *", modelName, "Repo) tombstone(ctx context.Context, tx *sql.Tx, ", tenantParam(t), "id, value string) error {")
+	g.P("  latest, err := r.latestVersions(ctx, tx, ", sqlArgs(t, "[]string{id}"), ")")
+	g.P("  if err != nil { return err }")
+	g.P("  field := ", ledgerPackage.Ident("Field"), "{Name: ", ledgerPackage.Ident("TombstoneField"), ", Value: value}")
+	g.P("  version := latest[id][field.Name] + 1")
+	g.P("  now := ", timePackage.Ident("Now"), "()")
+	g.P("  if _, err := tx.ExecContext(ctx, ", quotedQuery(d, insertQuery(t.ChainTable(), columns.chain)), ", ", sqlArgs(t, "id", "field.Name", "version", "field.Value", "now"), "); err != nil { return ", storePackage.Ident("VersionConflict"), "(err) }")
+	generateTombstonePayload(g, msg, t.Tenant, "version")
+	g.P("  _, err = tx.ExecContext(ctx, ", quotedQuery(d, insertQuery(t.OutboxTable(), columns.outbox)), ", ", sqlArgs(t, "id", "string(versionsJSON)", "payload", "now", "now"), ")")
+	g.P("  return err")
+	g.P("}")
+	g.P()
+
+	generateSQLFetchIncludingDeleted(g, msg, d, t, "sql.ErrNoRows", "r.querier().QueryRowContext")
+}
+
+// generatePgxSoftDelete generates the pgx Delete, Restore and
+// FetchIncludingDeleted of msg, and the tombstone they write. The PII row is
+// updated in a transaction of its own, or a savepoint, as Update does.
+func generatePgxSoftDelete(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+
+	for _, restore := range []bool{false, true} {
+		args := sqlArgs(t, "id")
+		if !restore {
+			args = "now, " + args
+		}
+		generateSoftDeleteDoc(g, restore, "pgx.ErrNoRows")
+		g.P("func (r *", modelName, "Repo) ", softDeleteMethod(restore), "(ctx context.Context, id string) error {")
+		generateRequireTenant(g, t.Tenant, "")
+		g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+		g.P("  return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {")
+		generateTombstoneValue(g, restore)
+		g.P("    tag, err := tx.Exec(ctx, ", quotedQuery(d, softDeleteQuery(msg, d, t, restore)), ", ", args, ")")
+		g.P("    if err != nil { return err }")
+		g.P("    if tag.RowsAffected() == 0 { return pgx.ErrNoRows }")
+		g.P("    return r.tombstone(ctx, tx, ", sqlArgs(t, "id", "value"), ")")
+		g.P("  })")
+		g.P("  })")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("// tombstone appends value as the ", ledgerPackage.Ident("TombstoneField"), " chain field of the record of id")
+	g.P("// and queues it for the ledger, in one batch.")
+	g.P("func (r *", modelName, "Repo) tombstone(ctx context.Context, tx pgx.Tx, ", tenantParam(t), "id, value string) error {")
+	g.P("  latest, err := r.latestVersions(ctx, tx, ", sqlArgs(t, "[]string{id}"), ")")
+	g.P("  if err != nil { return err }")
+	g.P("  field := ", ledgerPackage.Ident("Field"), "{Name: ", ledgerPackage.Ident("TombstoneField"), ", Value: value}")
+	g.P("  version := latest[id][field.Name] + 1")
+	g.P("  now := ", timePackage.Ident("Now"), "()")
+	generateTombstonePayload(g, msg, t.Tenant, "version")
+	g.P("  batch := &pgx.Batch{}")
+	g.P("  batch.Queue(", quotedQuery(d, insertQuery(t.ChainTable(), columns.chain)), ", ", sqlArgs(t, "id", "field.Name", "version", "field.Value", "now"), ")")
+	g.P("  batch.Queue(", quotedQuery(d, insertQuery(t.OutboxTable(), columns.outbox)), ", ", sqlArgs(t, "id", "string(versionsJSON)", "payload", "now", "now"), ")")
+	g.P("  return ", pgxstorePackage.Ident("SendBatch"), "(ctx, tx, batch, func(results pgx.BatchResults) error {")
+	g.P("    if _, err := results.Exec(); err != nil {")
+	g.P("      return ", storePackage.Ident("VersionConflict"), "(err)")
+	g.P("    }")
+	g.P("    _, err := results.Exec()")
+	g.P("    return err")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	generateSQLFetchIncludingDeleted(g, msg, d, t, "pgx.ErrNoRows", "r.db.QueryRow")
+}
+
+// tenantParam returns the tenantID parameter of the methods of a repository
+// of a multi-tenant t taking one, with its separator.
+func tenantParam(t Table) string {
+	if t.Tenant {
+		return "tenantID string, "
+	}
+	return ""
+}
+
+// generateSQLFetchIncludingDeleted generates the FetchIncludingDeleted of the
+// database/sql or pgx repository of msg, reading a row with queryRow.
+func generateSQLFetchIncludingDeleted(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, errNotFound, queryRow string) {
+	modelName := msg.GoIdent.GoName
+	query, dests := selectQuery(msg, d, t, includingDeletedTable(d, t))
+
+	g.P("// FetchIncludingDeleted returns the view of the record whether it is deleted or")
+	g.P("// not, or ", errNotFound, ".")
+	g.P("func (r *", modelName, "Repo) FetchIncludingDeleted(ctx context.Context, id string) (*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  var view ", modelName, "View")
+	g.P("  err := ", queryRow, "(ctx, ", quotedQuery(d, query+" WHERE "+sqlCondition(d, t, recordKeyName(msg))), ", ", sqlArgs(t, "id"), ").Scan(")
+	for _, dest := range dests {
+		g.P("    ", storePackage.Ident("Nullable"), "(", dest, "),")
+	}
+	g.P("  )")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &view, nil")
+	g.P("}")
+	g.P()
+}
+
+// generateFakeSoftDelete generates the Delete, Restore and
+// FetchIncludingDeleted of the fake of msg.
+func generateFakeSoftDelete(g *protogen.GeneratedFile, msg *protogen.Message, t Table, errNotFound string) {
+	fake := msg.GoIdent.GoName + "Fake"
+	tenantID := `""`
+	if t.Tenant {
+		tenantID = "tenantID"
+	}
+
+	for _, restore := range []bool{false, true} {
+		generateSoftDeleteDoc(g, restore, errNotFound)
+		g.P("func (r *", fake, ") ", softDeleteMethod(restore), "(ctx context.Context, id string) error {")
+		generateRequireTenant(g, t.Tenant, "")
+		g.P("  r.mu.Lock()")
+		g.P("  defer r.mu.Unlock()")
+		g.P("  pii, ok := r.pii.Get(", tenantID, ", id)")
+		if restore {
+			g.P("  if !ok || pii.DeletedAt == nil {")
+		} else {
+			g.P("  if !ok || pii.DeletedAt != nil {")
+		}
+		g.P("    return ", errNotFound)
+		g.P("  }")
+		g.P("  now := ", timePackage.Ident("Now"), "()")
+		if restore {
+			g.P("  pii.DeletedAt = nil")
+			g.P("  value := \"\"")
+		} else {
+			g.P("  pii.DeletedAt = &now")
+			g.P("  value := now.UTC().Format(", timePackage.Ident("RFC3339Nano"), ")")
+		}
+		g.P("  pii.RowVersion++")
+		g.P("  r.pii.Put(", tenantID, ", id, pii)")
+		g.P("  r.chain.Append(", tenantID, ", id, []", ledgerPackage.Ident("Field"), "{{Name: ", ledgerPackage.Ident("TombstoneField"), ", Value: value}}, now)")
+		g.P("  return nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("// FetchIncludingDeleted returns the view of the record whether it is deleted or")
+	g.P("// not, or ", errNotFound, ".")
+	g.P("func (r *", fake, ") FetchIncludingDeleted(ctx context.Context, id string) (*", msg.GoIdent.GoName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  view, ok := r.viewIncludingDeleted(", tenantID, ", id)")
+	g.P("  if !ok {")
+	g.P("    return nil, ", errNotFound)
+	g.P("  }")
+	g.P("  return view, nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,419 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func generateSQL(gen *protogen.Plugin, file *protogen.File, d Dialect, opts Options) {
+	filename := file.GeneratedFilenamePrefix + "_sdm_schema.sql"
+	g := gen.NewGeneratedFile(filename, "")
+
+	var tables []Table
+	for _, msg := range file.Messages {
+		tables = append(tables, NewTable(msg, opts))
+	}
+	// Tables follow the tables their foreign keys reference.
+	for _, t := range OrderByReferences(tables) {
+		stmts := append(SchemaStatements(d, t), AccessStatements(d, t, opts.Roles)...)
+		for _, stmt := range stmts {
+			g.P(stmt, ";")
+			g.P()
+		}
+	}
+}
+
+// SchemaStatements returns the statements creating the tables, indexes and
+// view of t.
+func SchemaStatements(d Dialect, t Table) []string {
+	stmts := []string{CreatePiiTable(d, t)}
+	stmts = append(stmts, PiiIndexes(d, t)...)
+	stmts = append(stmts, CreateChainTable(d, t)...)
+	stmts = append(stmts, ChainIndexes(d, t)...)
+	stmts = append(stmts, CreateView(d, t)...)
+	stmts = append(stmts, CreateOutboxTable(d, t))
+	stmts = append(stmts, OutboxIndex(d, t)...)
+	stmts = append(stmts, TenantPolicies(d, t)...)
+	return stmts
+}
+
+// CreatePiiTable returns the statement creating the PII table of t.
+func CreatePiiTable(d Dialect, t Table) string {
+	var lines []string
+	for _, c := range PiiColumns(d, t) {
+		lines = append(lines, "  "+columnDefinition(d, c))
+	}
+	if pk := t.PrimaryKey(); len(pk) > 0 {
+		if t.Tenant {
+			pk = append([]string{TenantColumn}, pk...)
+		}
+		lines = append(lines, "  PRIMARY KEY ("+quoteAll(d, pk)+")")
+	}
+	for _, f := range t.References() {
+		lines = append(lines, "  "+ForeignKey(d, t, f))
+	}
+	return "CREATE TABLE IF NOT EXISTS " + t.PiiTable() + " (\n" + strings.Join(lines, ",\n") + "\n)"
+}
+
+// rowVersionColumn returns the row_version column; rows start at version 1.
+func rowVersionColumn(d Dialect) Column {
+	return Column{Name: RowVersionColumn, Type: d.ColumnType(ColumnInteger, true), Constraints: "NOT NULL DEFAULT 1"}
+}
+
+func rowVersionColumnDefinition(d Dialect) string {
+	return columnDefinition(d, rowVersionColumn(d))
+}
+
+// ForeignKey returns the constraint of the PII table of t making field a
+// foreign key to the PII table it references; keys of multi-tenant tables
+// lead with tenant_id, so that records only reference records of their
+// tenant.
+func ForeignKey(d Dialect, t Table, field TableField) string {
+	columns, referenced := []string{field.Name}, []string{field.ReferencedColumn}
+	if t.Tenant {
+		columns = append([]string{TenantColumn}, columns...)
+		referenced = append([]string{TenantColumn}, referenced...)
+	}
+	return fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		t.ForeignKeyName(field.Name), quoteAll(d, columns), field.References, quoteAll(d, referenced))
+}
+
+// PiiIndexes returns the statements creating the indexes of the query_index
+// fields of t.
+func PiiIndexes(d Dialect, t Table) []string {
+	var stmts []string
+	for _, f := range t.Fields {
+		if f.QueryIndex && !f.PrimaryKey {
+			stmts = append(stmts, d.CreateIndex(t.IndexName(f.Name), t.PiiTable(), indexColumns(d, t, f.Name))...)
+		}
+	}
+	return stmts
+}
+
+// indexColumns returns the quoted columns of the index of field; indexes of
+// multi-tenant tables lead with tenant_id, which every query filters by.
+func indexColumns(d Dialect, t Table, field string) []string {
+	if t.Tenant {
+		return []string{d.Quote(TenantColumn), d.Quote(field)}
+	}
+	return []string{d.Quote(field)}
+}
+
+// Column is a column of a generated table.
+type Column struct {
+	Name string
+	Type string
+	// Constraints follow the type in the column definition.
+	Constraints string
+}
+
+// columnDefinition returns the definition of c in a CREATE or ALTER TABLE.
+func columnDefinition(d Dialect, c Column) string {
+	if c.Constraints == "" {
+		return d.Quote(c.Name) + " " + c.Type
+	}
+	return d.Quote(c.Name) + " " + c.Type + " " + c.Constraints
+}
+
+// PiiColumns returns the columns of the PII table of t.
+func PiiColumns(d Dialect, t Table) []Column {
+	var columns []Column
+	if t.Tenant {
+		columns = append(columns, tenantColumn(d))
+	}
+	for _, f := range t.Fields {
+		if f.InPii() {
+			columns = append(columns, Column{Name: f.Name, Type: d.ColumnType(f.Type, f.PrimaryKey || f.QueryIndex)})
+		}
+	}
+	if t.RowVersion {
+		columns = append(columns, rowVersionColumn(d))
+	}
+	if t.SoftDelete {
+		columns = append(columns, deletedAtColumn(d))
+	}
+	return columns
+}
+
+// ChainColumns returns the columns of the chain table of t.
+func ChainColumns(d Dialect, t Table) []Column {
+	var columns []Column
+	if t.Tenant {
+		columns = append(columns, tenantColumn(d))
+	}
+	return append(columns, []Column{
+		{Name: "key", Type: d.ColumnType(ColumnText, true), Constraints: "NOT NULL"},
+		{Name: "field_name", Type: d.ColumnType(ColumnText, true), Constraints: "NOT NULL"},
+		{Name: "version", Type: d.ColumnType(ColumnInteger, true), Constraints: "NOT NULL"},
+		{Name: "tx_hash", Type: d.ColumnType(ColumnText, false)},
+		{Name: "field_value", Type: d.ColumnType(ColumnText, false)},
+		{Name: "status", Type: d.ColumnType(ColumnText, true), Constraints: "NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'submitted', 'confirmed', 'failed'))"},
+		{Name: "created_at", Type: "TIMESTAMP", Constraints: "DEFAULT CURRENT_TIMESTAMP"},
+	}...)
+}
+
+// CreateChainTable returns the statements creating the chain table of t
+// and its partitions. Versions count per (key, field_name) and are assigned
+// by the repository.
+//
+// The primary key of a table partitioned by range of created_at includes
+// created_at, as Postgres requires of partitioned tables; a version written
+// twice is then not rejected by the table, only held off by the PII row
+// Save writes first.
+func CreateChainTable(d Dialect, t Table) []string {
+	var lines []string
+	for _, c := range ChainColumns(d, t) {
+		lines = append(lines, "  "+columnDefinition(d, c))
+	}
+	key := []string{"key", "field_name", "version"}
+	if t.Tenant {
+		key = append([]string{TenantColumn}, key...)
+	}
+	if t.ChainPartition == ChainPartitionRange {
+		key = append(key, "created_at")
+	}
+	lines = append(lines, "  PRIMARY KEY ("+quoteAll(d, key)+")")
+	create := "CREATE TABLE IF NOT EXISTS " + t.ChainTable() + " (\n" + strings.Join(lines, ",\n") + "\n)"
+
+	switch t.ChainPartition {
+	case ChainPartitionRange:
+		// Rows outside the ranges created by the operator land in the
+		// default partition.
+		return []string{
+			create + " PARTITION BY RANGE (created_at)",
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_default PARTITION OF %s DEFAULT", t.ChainTable(), t.ChainTable()),
+		}
+	case ChainPartitionHash:
+		stmts := []string{create + " PARTITION BY HASH (" + d.Quote("key") + ")"}
+		for i := 0; i < t.ChainPartitions; i++ {
+			stmts = append(stmts, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_p%d PARTITION OF %s FOR VALUES WITH (MODULUS %d, REMAINDER %d)",
+				t.ChainTable(), i, t.ChainTable(), t.ChainPartitions, i))
+		}
+		return stmts
+	}
+	return []string{create}
+}
+
+// ChainIndexes returns the statements creating the indexes of the chain
+// table of t besides its primary key. A partitioned table gets an index on
+// (status, created_at), which every partition inherits, so CompactChain
+// finds the old confirmed rows without scanning the partitions.
+func ChainIndexes(d Dialect, t Table) []string {
+	if t.ChainPartition == "" {
+		return nil
+	}
+	return d.CreateIndex(t.CompactIndex(), t.ChainTable(), []string{"status", "created_at"})
+}
+
+// CompactChainQuery returns the statement deleting the confirmed chain rows
+// of t created before the first parameter whose version is at least the
+// second parameter below the latest confirmed version of their field, which
+// is thus never deleted. Pending, submitted and failed rows are kept too.
+// Parameters are ? placeholders.
+func CompactChainQuery(d Dialect, t Table) string {
+	keys := []string{d.Quote("key"), "field_name"}
+	if t.Tenant {
+		keys = append([]string{d.Quote(TenantColumn)}, keys...)
+	}
+	chain := t.ChainTable()
+
+	// MySQL cannot select from the table it deletes from in a subquery,
+	// but can join a derived table of it.
+	if d.Name() == DialectMySQL {
+		var on []string
+		for _, k := range keys {
+			on = append(on, fmt.Sprintf("c.%s = n.%s", k, k))
+		}
+		return fmt.Sprintf("DELETE c FROM %s c JOIN (SELECT %s, MAX(version) AS latest FROM %s WHERE status = 'confirmed' GROUP BY %s) n ON %s WHERE c.status = 'confirmed' AND c.created_at < ? AND c.version <= n.latest - ?",
+			chain, strings.Join(keys, ", "), chain, strings.Join(keys, ", "), strings.Join(on, " AND "))
+	}
+	var match []string
+	for _, k := range keys {
+		match = append(match, fmt.Sprintf("n.%s = %s.%s", k, chain, k))
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE status = 'confirmed' AND created_at < ? AND version <= (SELECT MAX(n.version) FROM %s n WHERE n.status = 'confirmed' AND %s) - ?",
+		chain, chain, strings.Join(match, " AND "))
+}
+
+// chainStatusAggregate folds the statuses of a record's chain rows into a
+// single status: failed wins over pending, pending over submitted, and the
+// record is confirmed only once every row is.
+const chainStatusAggregate = "CASE MAX(CASE status WHEN 'failed' THEN 3 WHEN 'pending' THEN 2 WHEN 'submitted' THEN 1 ELSE 0 END) WHEN 3 THEN 'failed' WHEN 2 THEN 'pending' WHEN 1 THEN 'submitted' ELSE 'confirmed' END"
+
+// CreateView returns the statements (re)creating the view of t, which joins
+// the PII table with the latest value of every chain field.
+func CreateView(d Dialect, t Table) []string {
+	stmts := d.CreateView(t.View(), ViewQuery(d, t))
+	// Views bypass the row-level security of their tables unless they run
+	// as the invoker (PostgreSQL 15 or later).
+	if t.Tenant && d.Name() == DialectPostgres {
+		stmts = append(stmts, "ALTER VIEW "+t.View()+" SET (security_invoker = true)")
+	}
+	return stmts
+}
+
+// ViewVersion returns the version a view with query is tagged with, where
+// the database can store it, so stale views can be told apart.
+func ViewVersion(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return "sdm:" + hex.EncodeToString(sum[:])
+}
+
+// ViewQuery returns the query of the view of t.
+func ViewQuery(d Dialect, t Table) string {
+	return viewQuery(d, t, false, false)
+}
+
+// RedactedViewQuery returns the query of the redacted view of t, which
+// masks the PII columns with NULLs. Roles read it with the privileges of its
+// owner, so the view of a multi-tenant t filters by tenant itself.
+func RedactedViewQuery(d Dialect, t Table) string {
+	return viewQuery(d, t, true, false)
+}
+
+// IncludingDeletedQuery returns the query of the view of t, a soft-delete
+// table, with its deleted records.
+func IncludingDeletedQuery(d Dialect, t Table) string {
+	return viewQuery(d, t, false, true)
+}
+
+func viewQuery(d Dialect, t Table, redact, deleted bool) string {
+	key := d.Quote("key")
+	pk := "p." + keyColumn(d, t)
+
+	// Chain rows of multi-tenant messages are keyed by tenant too.
+	keys := []string{key}
+	if t.Tenant {
+		keys = []string{d.Quote(TenantColumn), key}
+	}
+	on := func(alias string) string {
+		cond := fmt.Sprintf("%s = %s.%s", pk, alias, key)
+		if t.Tenant {
+			cond += fmt.Sprintf(" AND p.%s = %s.%s", d.Quote(TenantColumn), alias, d.Quote(TenantColumn))
+		}
+		return cond
+	}
+
+	selects := []string{}
+	joins := []string{}
+	if t.Tenant {
+		selects = append(selects, "p."+d.Quote(TenantColumn))
+	}
+	latestField := func(name string, typ ColumnType) {
+		alias := "c_" + name
+		latest := d.Latest(t.ChainTable(), append(keys, "field_name"), append([]string{"field_value"}, keys...), "version DESC", fmt.Sprintf("field_name='%s'", name))
+		joins = append(joins, fmt.Sprintf("LEFT JOIN (%s) %s ON %s", latest, alias, on(alias)))
+		value := alias + ".field_value"
+		// Chain values are stored as text; integers are cast back so that
+		// the view compares and orders them as numbers.
+		if typ == ColumnInteger {
+			value = d.Cast(value, typ)
+		}
+		selects = append(selects, fmt.Sprintf("%s AS %s", value, d.Quote(name)))
+	}
+
+	// PII table alias p
+	for _, f := range t.Fields {
+		if f.InPii() && redact && f.Pii && !f.PrimaryKey {
+			selects = append(selects, d.Cast("NULL", f.Type)+" AS "+d.Quote(f.Name))
+		} else if f.InPii() {
+			// Available in PII table
+			selects = append(selects, "p."+d.Quote(f.Name))
+		} else {
+			// It's a chain field
+			latestField(f.Name, f.Type)
+		}
+		if f.Hashed {
+			// Also need the hashed value
+			latestField("hashed_"+f.Name, ColumnText)
+		}
+	}
+
+	// Ledger state: the latest confirmed transaction, and the worst status
+	// among the latest version of every chain field of the record. The l_
+	// aliases cannot collide with the c_ aliases of the fields. Versions
+	// count per field, so the latest confirmed row of the record is the last
+	// written one, whichever its field.
+	scope := strings.Join(keys, ", ")
+	latestTx := d.Latest(t.ChainTable(), keys, append(keys, "tx_hash"), "created_at DESC, version DESC", "status = 'confirmed'")
+	joins = append(joins, fmt.Sprintf("LEFT JOIN (%s) l_tx ON %s", latestTx, on("l_tx")))
+	latestStatus := d.Latest(t.ChainTable(), append(keys, "field_name"), append(keys, "status"), "version DESC", "")
+	joins = append(joins, fmt.Sprintf("LEFT JOIN (SELECT %s, %s AS chain_status FROM (%s) l GROUP BY %s) l_status ON %s", scope, chainStatusAggregate, latestStatus, scope, on("l_status")))
+	if t.RowVersion {
+		selects = append(selects, "p."+d.Quote(RowVersionColumn))
+	}
+	if t.SoftDelete {
+		selects = append(selects, "p."+d.Quote(DeletedAtColumn))
+	}
+	selects = append(selects, "l_tx.tx_hash", "COALESCE(l_status.chain_status, 'pending') AS chain_status")
+
+	var b strings.Builder
+	b.WriteString("  SELECT\n    ")
+	b.WriteString(strings.Join(selects, ",\n    "))
+	b.WriteString("\n  FROM " + t.PiiTable() + " p")
+	for _, join := range joins {
+		b.WriteString("\n  " + join)
+	}
+	var where []string
+	if redact && t.Tenant {
+		where = append(where, "p."+tenantCondition)
+	}
+	if t.SoftDelete && !deleted {
+		where = append(where, "p."+d.Quote(DeletedAtColumn)+" IS NULL")
+	}
+	if len(where) > 0 {
+		b.WriteString("\n  WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// keyColumn returns the quoted column of the PII table of t that chain rows
+// are keyed by.
+func keyColumn(d Dialect, t Table) string {
+	if fields := t.PrimaryKey(); len(fields) == 1 {
+		return d.Quote(fields[0])
+	}
+	return d.Quote("id")
+}
+
+// CreateOutboxTable returns the statement creating the outbox table of t.
+func CreateOutboxTable(d Dialect, t Table) string {
+	lines := []string{
+		"  " + d.SerialPrimaryKey("id"),
+	}
+	if t.Tenant {
+		lines = append(lines, "  "+tenantColumnDefinition(d))
+	}
+	lines = append(lines, []string{
+		"  " + d.Quote("key") + " " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  versions " + d.ColumnType(ColumnText, false) + " NOT NULL",
+		"  payload " + d.ColumnType(ColumnBytes, false) + " NOT NULL",
+		"  status " + d.ColumnType(ColumnText, true) + " NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed'))",
+		"  attempts INTEGER NOT NULL DEFAULT 0",
+		"  next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"  last_error " + d.ColumnType(ColumnText, false),
+		"  tx_hash " + d.ColumnType(ColumnText, false),
+		"  delivered_at TIMESTAMP",
+		"  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	}...)
+	return "CREATE TABLE IF NOT EXISTS " + t.OutboxTable() + " (\n" + strings.Join(lines, ",\n") + "\n)"
+}
+
+// OutboxIndex returns the statements creating the index the dispatcher polls
+// the outbox table of t with.
+func OutboxIndex(d Dialect, t Table) []string {
+	return d.CreateIndex(t.OutboxTable()+"_pending", t.OutboxTable(), []string{"status", "next_attempt_at"})
+}
+
+func quoteAll(d Dialect, idents []string) string {
+	quoted := make([]string, len(idents))
+	for i, ident := range idents {
+		quoted[i] = d.Quote(ident)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,825 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// generateSQLRepo generates the repositories of the messages of file on
+// database/sql only, with the queries written for d.
+func generateSQLRepo(gen *protogen.Plugin, file *protogen.File, d Dialect, opts Options) {
+	filename := file.GeneratedFilenamePrefix + "_sdm_repo.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import (")
+	g.P(`	"context"`)
+	g.P(`	"database/sql"`)
+	g.P(`	"fmt"`)
+	g.P(")")
+	g.P()
+
+	for _, msg := range file.Messages {
+		modelName := msg.GoIdent.GoName
+		t := NewTable(msg, opts)
+
+		generateStoreInterface(g, file, msg, t)
+
+		g.P("// ", modelName, "Repo stores ", modelName, " records with database/sql.")
+		g.P("type ", modelName, "Repo struct {")
+		g.P("  db        *sql.DB")
+		g.P("  tx        *sql.Tx")
+		g.P("  batchSize int")
+		g.P("}")
+		g.P()
+
+		g.P("func New", modelName, "Repo(db *sql.DB) *", modelName, "Repo {")
+		g.P("  return &", modelName, "Repo{db: db, batchSize: ", storePackage.Ident("DefaultBatchSize"), "}")
+		g.P("}")
+		g.P()
+		g.P("var _ ", modelName, "Store = (*", modelName, "Repo)(nil)")
+		g.P()
+
+		generateWithBatchSize(g, msg)
+		generateWithTx(g, msg, "*sql.Tx", "tx")
+		g.P("// querier returns the transaction the repository runs in, or its database.")
+		g.P("func (r *", modelName, "Repo) querier() ", storePackage.Ident("Querier"), " {")
+		g.P("  if r.tx != nil {")
+		g.P("    return r.tx")
+		g.P("  }")
+		g.P("  return r.db")
+		g.P("}")
+		g.P()
+		generateSQLSave(g, msg, d, t, opts)
+		generateSQLUpdate(g, msg, d, t, opts)
+		if t.SoftDelete {
+			generateSQLSoftDelete(g, msg, d, t)
+		}
+		generateSQLSaveBatch(g, msg, d, t, opts)
+		generateSQLLatestVersions(g, msg, d, t)
+		generateSQLFetch(g, msg, d, t)
+		generateSQLFetchWith(g, file, msg, d, opts)
+		generateSQLList(g, msg, d, t)
+		generateSQLChainWriteMethods(g, msg, d, t)
+		generateSQLMerkleRoot(g, msg, d, t)
+		generateVerifyFieldProof(g, msg, modelName+"Repo")
+		generateSQLPublishedHashes(g, msg, d, t)
+		generateHashVerifyMethods(g, msg, modelName+"Repo")
+		generateSQLOutboxStore(g, msg, d, t)
+	}
+	generateFileStore(g, gen, file, opts.Backend, "*sql.DB")
+}
+
+// bind replaces the ? placeholders of query with the placeholders of d.
+func bind(d Dialect, query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// numberedPlaceholders reports whether the placeholders of d are numbered.
+func numberedPlaceholders(d Dialect) bool {
+	return d.Placeholder(1) != "?"
+}
+
+// sqlCondition returns the condition comparing each of columns to a ?
+// placeholder, led by tenant_id for a multi-tenant t.
+func sqlCondition(d Dialect, t Table, columns ...string) string {
+	if t.Tenant {
+		columns = append([]string{TenantColumn}, columns...)
+	}
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		conds[i] = d.Quote(c) + " = ?"
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// sqlArgs returns the Go source of the query arguments args, led by the
+// tenantID variable for a multi-tenant t.
+func sqlArgs(t Table, args ...string) string {
+	if t.Tenant {
+		args = append([]string{"tenantID"}, args...)
+	}
+	return strings.Join(args, ", ")
+}
+
+// insertQuery returns the INSERT of a row of columns into table.
+func insertQuery(table string, columns []string) string {
+	return insertPrefix(table, columns) + "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+}
+
+// insertPrefix returns the INSERT into columns of table up to its VALUES list.
+func insertPrefix(table string, columns []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+}
+
+// quotedQuery returns the Go string literal of query with the placeholders
+// of d.
+func quotedQuery(d Dialect, query string) string {
+	return fmt.Sprintf("%q", bind(d, query))
+}
+
+// saveColumns holds the columns the PII, chain and outbox rows written by
+// Save are inserted with, and the Go source of the PII values of model.
+type saveColumns struct {
+	pkField   string
+	pii       []string
+	piiValues []string
+	chain     []string
+	outbox    []string
+}
+
+func newSaveColumns(msg *protogen.Message, d Dialect, t Table) saveColumns {
+	var c saveColumns
+	tenant := func(columns ...string) []string {
+		if t.Tenant {
+			return append([]string{d.Quote(TenantColumn)}, columns...)
+		}
+		return columns
+	}
+	c.pii = tenant()
+	if t.Tenant {
+		c.piiValues = append(c.piiValues, "tenantID")
+	}
+	for _, field := range msg.Fields {
+		fieldOpts := getFieldOptions(field)
+		if fieldOpts.PrimaryKey {
+			c.pkField = field.GoName
+		}
+		if fieldOpts.PrimaryKey || fieldOpts.Pii || fieldOpts.QueryIndex {
+			c.pii = append(c.pii, d.Quote(string(field.Desc.Name())))
+			c.piiValues = append(c.piiValues, "model."+field.GoName)
+		}
+	}
+	c.chain = tenant(d.Quote("key"), "field_name", "version", "field_value", "created_at")
+	c.outbox = tenant(d.Quote("key"), "versions", "payload", "next_attempt_at", "created_at")
+	return c
+}
+
+func generateSQLSave(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+
+	g.P("func (r *", modelName, "Repo) Save(ctx context.Context, model *", modelName, ") error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return ", storePackage.Ident("InTx"), "(ctx, r.db, r.tx, func(tx *sql.Tx) error {")
+	g.P("    if _, err := tx.ExecContext(ctx, ", quotedQuery(d, insertQuery(t.PiiTable(), columns.pii)), ", ", strings.Join(columns.piiValues, ", "), "); err != nil { return err }")
+	g.P()
+	generateSQLSaveChain(g, msg, d, t, opts)
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
+
+// generateSQLSaveChain generates the end of the transaction of the
+// database/sql Save and Update of msg, run on tx after the PII row of model
+// is written: the chain rows of its chain fields and Merkle root, and their
+// outbox entry.
+func generateSQLSaveChain(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	columns := newSaveColumns(msg, d, t)
+	pkField := columns.pkField
+
+	g.P("    // Save Chain Fields")
+	g.P("    // Versions count per (key, field_name). The PII row written above holds")
+	g.P("    // off concurrent writers of the record; a version taken anyway is retried.")
+	g.P("    latest, err := r.latestVersions(ctx, tx, ", sqlArgs(t, "[]string{model."+pkField+"}"), ")")
+	g.P("    if err != nil { return err }")
+	g.P("    insertChain, err := tx.PrepareContext(ctx, ", quotedQuery(d, insertQuery(t.ChainTable(), columns.chain)), ")")
+	g.P("    if err != nil { return err }")
+	g.P("    defer insertChain.Close()")
+	g.P("    now := ", timePackage.Ident("Now"), "()")
+	g.P("    var writes []", ledgerPackage.Ident("FieldVersion"))
+	g.P("    appendChain := func(name, value string) error {")
+	g.P("      version := latest[model.", pkField, "][name] + 1")
+	g.P("      if _, err := insertChain.ExecContext(ctx, ", sqlArgs(t, "model."+pkField, "name", "version", "value", "now"), "); err != nil { return ", storePackage.Ident("VersionConflict"), "(err) }")
+	g.P("      writes = append(writes, ", ledgerPackage.Ident("FieldVersion"), "{Field: name, Version: version})")
+	g.P("      return nil")
+	g.P("    }")
+	g.P("    for _, field := range model.ChainFields() {")
+	g.P("      if err := appendChain(field.Name, field.Value); err != nil { return err }")
+	g.P("    }")
+	g.P()
+
+	g.P("    // Save Merkle Root of the Chain Fields")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil { return err }")
+	g.P("    if err := appendChain(", merklePackage.Ident("RootField"), ", tree.RootHex()); err != nil { return err }")
+	g.P()
+
+	g.P("    // Queue Chain Fields for the ledger")
+	generateOutboxPayload(g, opts, t.Tenant, "    ")
+	g.P("    _, err = tx.ExecContext(ctx, ", quotedQuery(d, insertQuery(t.OutboxTable(), columns.outbox)), ", ", sqlArgs(t, "model."+pkField, "string(versionsJSON)", "payload", "now", "now"), ")")
+	g.P("    return err")
+}
+
+// generateSaveRows generates the loop building the PII, chain and outbox rows
+// of models, as piiRows, chainRows and outboxRows of []any, for the
+// multi-row writes of the database/sql and pgx backends. latest holds the
+// latest chain versions of the records by key.
+func generateSaveRows(g *protogen.GeneratedFile, t Table, columns saveColumns, opts Options) {
+	key := "model." + columns.pkField
+
+	g.P("  now := ", timePackage.Ident("Now"), "()")
+	g.P("  piiRows := make([][]any, 0, len(models))")
+	g.P("  outboxRows := make([][]any, 0, len(models))")
+	g.P("  var chainRows [][]any")
+	g.P("  for _, model := range models {")
+	g.P("    piiRows = append(piiRows, []any{", strings.Join(columns.piiValues, ", "), "})")
+	g.P("    var writes []", ledgerPackage.Ident("FieldVersion"))
+	g.P("    appendChain := func(name, value string) {")
+	g.P("      version := latest[", key, "][name] + 1")
+	g.P("      chainRows = append(chainRows, []any{", sqlArgs(t, key, "name", "version", "value", "now"), "})")
+	g.P("      writes = append(writes, ", ledgerPackage.Ident("FieldVersion"), "{Field: name, Version: version})")
+	g.P("    }")
+	g.P("    for _, field := range model.ChainFields() {")
+	g.P("      appendChain(field.Name, field.Value)")
+	g.P("    }")
+	g.P("    tree, err := model.MerkleTree()")
+	g.P("    if err != nil { return err }")
+	g.P("    appendChain(", merklePackage.Ident("RootField"), ", tree.RootHex())")
+	generateOutboxPayload(g, opts, t.Tenant, "    ")
+	g.P("    outboxRows = append(outboxRows, []any{", sqlArgs(t, key, "string(versionsJSON)", "payload", "now", "now"), "})")
+	g.P("  }")
+}
+
+func generateSQLSaveBatch(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	columns := newSaveColumns(msg, d, t)
+	numbered := numberedPlaceholders(d)
+
+	g.P("// SaveBatch saves models as Save does, in one transaction. The rows are written")
+	g.P("// with multi-row inserts and the chain versions looked up per batch of the")
+	g.P("// batch size of the repository.")
+	g.P("func (r *", modelName, "Repo) SaveBatch(ctx context.Context, models []*", modelName, ") error {")
+	g.P("  if len(models) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return ", storePackage.Ident("InTx"), "(ctx, r.db, r.tx, func(tx *sql.Tx) error {")
+	g.P("    for _, batch := range ", storePackage.Ident("Batches"), "(models, r.batchSize) {")
+	g.P("      if err := r.saveBatch(ctx, tx, ", sqlArgs(t, "batch"), "); err != nil {")
+	g.P("        return err")
+	g.P("      }")
+	g.P("    }")
+	g.P("    return nil")
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("// saveBatch writes the rows of models with one multi-row insert per table, and")
+	g.P("// per batch of chain rows.")
+	if t.Tenant {
+		g.P("func (r *", modelName, "Repo) saveBatch(ctx context.Context, tx *sql.Tx, tenantID string, models []*", modelName, ") error {")
+	} else {
+		g.P("func (r *", modelName, "Repo) saveBatch(ctx context.Context, tx *sql.Tx, models []*", modelName, ") error {")
+	}
+	g.P("  keys := make([]string, len(models))")
+	g.P("  for i, model := range models {")
+	g.P("    keys[i] = model.", columns.pkField)
+	g.P("  }")
+	g.P("  latest, err := r.latestVersions(ctx, tx, ", sqlArgs(t, "keys"), ")")
+	g.P("  if err != nil { return err }")
+	generateSaveRows(g, t, columns, opts)
+	g.P()
+	g.P("  values, args := ", storePackage.Ident("Values"), "(", numbered, ", piiRows)")
+	g.P("  if _, err := tx.ExecContext(ctx, ", quotedQuery(d, insertPrefix(t.PiiTable(), columns.pii)), "+values, args...); err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  for _, batch := range ", storePackage.Ident("Batches"), "(chainRows, r.batchSize) {")
+	g.P("    values, args := ", storePackage.Ident("Values"), "(", numbered, ", batch)")
+	g.P("    if _, err := tx.ExecContext(ctx, ", quotedQuery(d, insertPrefix(t.ChainTable(), columns.chain)), "+values, args...); err != nil {")
+	g.P("      return ", storePackage.Ident("VersionConflict"), "(err)")
+	g.P("    }")
+	g.P("  }")
+	g.P("  values, args = ", storePackage.Ident("Values"), "(", numbered, ", outboxRows)")
+	g.P("  _, err = tx.ExecContext(ctx, ", quotedQuery(d, insertPrefix(t.OutboxTable(), columns.outbox)), "+values, args...)")
+	g.P("  return err")
+	g.P("}")
+	g.P()
+}
+
+func generateSQLLatestVersions(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	first := 1
+	cond := d.Quote("key") + " IN ("
+	if t.Tenant {
+		first++
+		cond = sqlCondition(d, t) + " AND " + cond
+	}
+
+	g.P("// latestVersions returns the latest chain version of every field of the records")
+	g.P("// of keys, by key.")
+	if t.Tenant {
+		g.P("func (r *", modelName, "Repo) latestVersions(ctx context.Context, q ", storePackage.Ident("Querier"), ", tenantID string, keys []string) (map[string]map[string]int64, error) {")
+	} else {
+		g.P("func (r *", modelName, "Repo) latestVersions(ctx context.Context, q ", storePackage.Ident("Querier"), ", keys []string) (map[string]map[string]int64, error) {")
+	}
+	g.P("  args := make([]any, 0, len(keys)+1)")
+	if t.Tenant {
+		g.P("  args = append(args, tenantID)")
+	}
+	g.P("  for _, key := range keys {")
+	g.P("    args = append(args, key)")
+	g.P("  }")
+	g.P("  query := ", quotedQuery(d, fmt.Sprintf("SELECT %s, field_name, MAX(version) FROM %s WHERE %s", d.Quote("key"), t.ChainTable(), cond)), " +")
+	g.P("    ", storePackage.Ident("Placeholders"), "(", numberedPlaceholders(d), ", ", first, ", len(keys)) + ", fmt.Sprintf("%q", ") GROUP BY "+d.Quote("key")+", field_name"))
+	g.P("  rows, err := q.QueryContext(ctx, query, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  latest := make(map[string]map[string]int64, len(keys))")
+	g.P("  for rows.Next() {")
+	g.P("    var key, name string")
+	g.P("    var version int64")
+	g.P("    if err := rows.Scan(&key, &name, &version); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    if latest[key] == nil {")
+	g.P("      latest[key] = make(map[string]int64)")
+	g.P("    }")
+	g.P("    latest[key][name] = version")
+	g.P("  }")
+	g.P("  return latest, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+// selectViewQuery returns the SELECT of the view columns of records, without its
+// WHERE clause, and the Go source of the fields of the view variable its
+// columns are scanned into.
+func selectViewQuery(msg *protogen.Message, d Dialect, t Table) (string, []string) {
+	return selectQuery(msg, d, t, t.View())
+}
+
+// selectQuery returns the SELECT of the view columns of records from from,
+// the view or a derived table of its columns, as selectViewQuery does.
+func selectQuery(msg *protogen.Message, d Dialect, t Table, from string) (string, []string) {
+	var columns, dests []string
+	if t.Tenant {
+		columns = append(columns, d.Quote(TenantColumn))
+		dests = append(dests, "&view.TenantID")
+	}
+	for _, field := range msg.Fields {
+		columns = append(columns, d.Quote(string(field.Desc.Name())))
+		dests = append(dests, "&view."+field.GoName)
+		if getFieldOptions(field).Hashed {
+			columns = append(columns, d.Quote("hashed_"+string(field.Desc.Name())))
+			dests = append(dests, "&view.Hashed"+field.GoName)
+		}
+	}
+	if t.RowVersion {
+		columns = append(columns, d.Quote(RowVersionColumn))
+		dests = append(dests, "&view.RowVersion")
+	}
+	if t.SoftDelete {
+		columns = append(columns, d.Quote(DeletedAtColumn))
+		dests = append(dests, "&view.DeletedAt")
+	}
+	columns = append(columns, "tx_hash", "chain_status")
+	dests = append(dests, "&view.TxHash", "&view.ChainStatus")
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), from), dests
+}
+
+func generateSQLFetch(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	nullable := storePackage.Ident("Nullable")
+	query, dests := selectViewQuery(msg, d, t)
+
+	g.P("// Fetch returns the view of the record, or sql.ErrNoRows.")
+	g.P("func (r *", modelName, "Repo) Fetch(ctx context.Context, id string) (*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  var view ", modelName, "View")
+	g.P("  // Chain fields without rows, and the tx_hash of unconfirmed records, are NULL.")
+	g.P("  err := r.querier().QueryRowContext(ctx, ", quotedQuery(d, query+" WHERE "+sqlCondition(d, t, recordKeyName(msg))), ", ", sqlArgs(t, "id"), ").Scan(")
+	for _, dest := range dests {
+		g.P("    ", nullable, "(", dest, "),")
+	}
+	g.P("  )")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &view, nil")
+	g.P("}")
+	g.P()
+
+	first := 1
+	cond := d.Quote(recordKeyName(msg)) + " IN ("
+	if t.Tenant {
+		first++
+		cond = sqlCondition(d, t) + " AND " + cond
+	}
+	g.P("// FetchMany returns the views of the records of ids by id, reading them the")
+	g.P("// batch size of the repository at a time. Missing records are left out.")
+	g.P("func (r *", modelName, "Repo) FetchMany(ctx context.Context, ids []string) (map[string]*", modelName, "View, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	g.P("  views := make(map[string]*", modelName, "View, len(ids))")
+	g.P("  for _, batch := range ", storePackage.Ident("Batches"), "(ids, r.batchSize) {")
+	g.P("    args := make([]any, 0, len(batch)+1)")
+	if t.Tenant {
+		g.P("    args = append(args, tenantID)")
+	}
+	g.P("    for _, id := range batch {")
+	g.P("      args = append(args, id)")
+	g.P("    }")
+	g.P("    query := ", quotedQuery(d, query+" WHERE "+cond), " + ", storePackage.Ident("Placeholders"), "(", numberedPlaceholders(d), ", ", first, ", len(batch)) + \")\"")
+	g.P("    rows, err := r.queryViews(ctx, query, args...)")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    for i := range rows {")
+	g.P("      views[rows[i].", recordKeyField(msg), "] = &rows[i]")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return views, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// queryViews returns the views query returns.")
+	g.P("func (r *", modelName, "Repo) queryViews(ctx context.Context, query string, args ...any) ([]", modelName, "View, error) {")
+	g.P("  rows, err := r.querier().QueryContext(ctx, query, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var views []", modelName, "View")
+	g.P("  for rows.Next() {")
+	g.P("    var view ", modelName, "View")
+	g.P("    err := rows.Scan(")
+	for _, dest := range dests {
+		g.P("      ", nullable, "(", dest, "),")
+	}
+	g.P("    )")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    views = append(views, view)")
+	g.P("  }")
+	g.P("  return views, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+// sqlChainColumns returns the columns of the chain table of t read by the
+// database/sql repositories, with the Go field of the chain model each is
+// scanned into.
+func sqlChainColumns(d Dialect, t Table) (columns, fields []string) {
+	for _, c := range ChainColumns(d, t) {
+		columns = append(columns, d.Quote(c.Name))
+		fields = append(fields, goFieldName(c.Name))
+	}
+	return columns, fields
+}
+
+// goFieldName returns the Go field name of a snake_case column.
+func goFieldName(column string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(column, "_") {
+		if part == "id" {
+			b.WriteString("ID")
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// pendingChainQuery returns the query of the chain columns of a record that
+// are not in the status of the last parameter.
+func pendingChainQuery(d Dialect, t Table, columns []string) string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s AND status <> ? ORDER BY version", strings.Join(columns, ", "), t.ChainTable(), sqlCondition(d, t, "key"))
+}
+
+func generateSQLChainWriteMethods(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	status := ledgerPackage.Ident("Status")
+	querier := storePackage.Ident("Querier")
+	columns, fields := sqlChainColumns(d, t)
+
+	g.P("// PendingChainWrites returns the chain rows of the record that are not confirmed on the ledger yet.")
+	g.P("func (r *", modelName, "Repo) PendingChainWrites(ctx context.Context, key string) ([]", modelName, "Chain, error) {")
+	generateRequireTenant(g, t.Tenant, "nil")
+	query := pendingChainQuery(d, t, columns)
+	g.P("  rows, err := r.querier().QueryContext(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, "key", g.QualifiedGoIdent(ledgerPackage.Ident("StatusConfirmed"))), ")")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var chain []", modelName, "Chain")
+	g.P("  for rows.Next() {")
+	g.P("    var row ", modelName, "Chain")
+	g.P("    err := rows.Scan(")
+	for _, field := range fields {
+		g.P("      ", storePackage.Ident("Nullable"), "(&row.", field, "),")
+	}
+	g.P("    )")
+	g.P("    if err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    chain = append(chain, row)")
+	g.P("  }")
+	g.P("  return chain, rows.Err()")
+	g.P("}")
+	g.P()
+
+	generateMarkChainWriteDoc(g)
+	g.P("func (r *", modelName, "Repo) MarkChainWrite(ctx context.Context, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", status, ", txHash string) error {")
+	g.P("  if !status.Valid() {")
+	g.P("    return fmt.Errorf(\"invalid chain status %q\", status)")
+	g.P("  }")
+	g.P("  if len(writes) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return mark", modelName, "ChainWrite(ctx, r.querier(), ", sqlArgs(t, "key", "writes", "status", "txHash"), ")")
+	g.P("}")
+	g.P()
+
+	// The outbox marks the rows of its entries in its own transaction.
+	update := fmt.Sprintf("UPDATE %s SET status = ?, tx_hash = COALESCE(NULLIF(?, ''), tx_hash) WHERE %s AND ", t.ChainTable(), sqlCondition(d, t, "key"))
+	params := 3
+	if t.Tenant {
+		params++
+	}
+	g.P("// mark", modelName, "ChainWrite is MarkChainWrite with q.")
+	if t.Tenant {
+		g.P("func mark", modelName, "ChainWrite(ctx context.Context, q ", querier, ", tenantID, key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", status, ", txHash string) error {")
+	} else {
+		g.P("func mark", modelName, "ChainWrite(ctx context.Context, q ", querier, ", key string, writes []", ledgerPackage.Ident("FieldVersion"), ", status ", status, ", txHash string) error {")
+	}
+	g.P("  if len(writes) == 0 {")
+	g.P("    return nil")
+	g.P("  }")
+	g.P("  args := []any{", strings.Join(append([]string{"status", "txHash"}, sqlArgs(t, "key")), ", "), "}")
+	g.P("  cond, writeArgs := ", storePackage.Ident("FieldVersions"), "(", numberedPlaceholders(d), ", ", params, ", writes)")
+	g.P("  res, err := q.ExecContext(ctx, ", quotedQuery(d, update), "+cond, append(args, writeArgs...)...)")
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  n, err := res.RowsAffected()")
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  if n == 0 {")
+	g.P("    return fmt.Errorf(\"no chain rows for key %q at %v\", key, writes)")
+	g.P("  }")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+
+	generateConfirmChainWrite(g, msg, modelName+"Repo")
+
+	g.P("// CompactChain deletes the chain rows created before the given time that are")
+	g.P("// confirmed on the ledger and superseded: the latest keepVersions versions up to")
+	g.P("// the latest confirmed version of every field are kept, as are the rows not")
+	g.P("// confirmed yet. It spans every record, and every tenant, and returns the")
+	g.P("// number of rows deleted. Pruned versions can no longer be verified.")
+	g.P("func (r *", modelName, "Repo) CompactChain(ctx context.Context, before ", timePackage.Ident("Time"), ", keepVersions int) (int64, error) {")
+	g.P("  if keepVersions < 1 {")
+	g.P("    return 0, fmt.Errorf(\"compacting chain: keepVersions must be at least 1, got %d\", keepVersions)")
+	g.P("  }")
+	g.P("  res, err := r.querier().ExecContext(ctx, ", quotedQuery(d, CompactChainQuery(d, t)), ", before, keepVersions)")
+	g.P("  if err != nil {")
+	g.P("    return 0, err")
+	g.P("  }")
+	g.P("  return res.RowsAffected()")
+	g.P("}")
+	g.P()
+}
+
+// merkleRootQuery returns the query of the latest value of a chain field of
+// a record.
+func merkleRootQuery(d Dialect, t Table) string {
+	return fmt.Sprintf("SELECT field_value FROM %s WHERE %s ORDER BY version DESC LIMIT 1", t.ChainTable(), sqlCondition(d, t, "key", "field_name"))
+}
+
+func generateSQLMerkleRoot(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	query := merkleRootQuery(d, t)
+
+	g.P("// MerkleRoot returns the latest Merkle root committed for the record.")
+	g.P("func (r *", modelName, "Repo) MerkleRoot(ctx context.Context, key string) (string, error) {")
+	generateRequireTenant(g, t.Tenant, `""`)
+	g.P("  var root string")
+	g.P("  err := r.querier().QueryRowContext(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, "key", g.QualifiedGoIdent(merklePackage.Ident("RootField"))), ").Scan(", storePackage.Ident("Nullable"), "(&root))")
+	g.P("  if err != nil {")
+	g.P("    return \"\", err")
+	g.P("  }")
+	g.P("  return root, nil")
+	g.P("}")
+	g.P()
+}
+
+// publishedHashesQuery returns the query of the hashes of the hashed fields
+// of a record, latest first, and the Go source of their chain field names,
+// its parameters after the key.
+func publishedHashesQuery(d Dialect, t Table, hashed []*protogen.Field) (string, []string) {
+	names := make([]string, len(hashed))
+	for i, field := range hashed {
+		names[i] = fmt.Sprintf("%q", "hashed_"+string(field.Desc.Name()))
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(hashed)), ", ")
+	return fmt.Sprintf("SELECT field_name, field_value FROM %s WHERE %s AND field_name IN (%s) ORDER BY version DESC", t.ChainTable(), sqlCondition(d, t, "key"), in), names
+}
+
+func generateSQLPublishedHashes(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	hashed := hashedFields(msg)
+
+	g.P("// publishedHashes returns the latest published hash of every hashed field of")
+	g.P("// the record, keyed by chain field name.")
+	g.P("func (r *", modelName, "Repo) publishedHashes(ctx context.Context, key string) (map[string]string, error) {")
+	if len(hashed) == 0 {
+		g.P("  return map[string]string{}, nil")
+		g.P("}")
+		g.P()
+		return
+	}
+	generateRequireTenant(g, t.Tenant, "nil")
+	query, names := publishedHashesQuery(d, t, hashed)
+	g.P("  rows, err := r.querier().QueryContext(ctx, ", quotedQuery(d, query), ", ", sqlArgs(t, append([]string{"key"}, names...)...), ")")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  published := make(map[string]string, ", len(hashed), ")")
+	g.P("  for rows.Next() {")
+	g.P("    var name, value string")
+	g.P("    if err := rows.Scan(&name, ", storePackage.Ident("Nullable"), "(&value)); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    if _, ok := published[name]; !ok {")
+	g.P("      published[name] = value")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return published, rows.Err()")
+	g.P("}")
+	g.P()
+}
+
+// outboxPendingQuery returns the query of the pending outbox entries due at
+// or before the second parameter, oldest first.
+func outboxPendingQuery(d Dialect, t Table) string {
+	key := d.Quote("key")
+	if t.Tenant {
+		key = d.Quote(TenantColumn) + ", " + key
+	}
+	return fmt.Sprintf("SELECT id, %s, payload, attempts FROM %s WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?", key, t.OutboxTable())
+}
+
+// outboxEntryScan returns the Go source of the fields of the outbox.Entry e
+// the columns of outboxPendingQuery are scanned into.
+func outboxEntryScan(t Table) string {
+	if t.Tenant {
+		return "&e.ID, &e.TenantID, &e.Key, &e.Payload, &e.Attempts"
+	}
+	return "&e.ID, &e.Key, &e.Payload, &e.Attempts"
+}
+
+// outboxUpdate returns the UPDATE setting set on an outbox entry by id and
+// status, the last two parameters.
+func outboxUpdate(t Table, set string) string {
+	return fmt.Sprintf("UPDATE %s SET %s WHERE id = ? AND status = ?", t.OutboxTable(), set)
+}
+
+// outboxEntryQuery returns the query of the columns of an outbox entry its
+// chain rows are found with, and the Go source of the fields of the row
+// variable they are scanned into.
+func outboxEntryQuery(d Dialect, t Table) (string, []string) {
+	columns := []string{d.Quote("key"), "versions"}
+	dests := []string{"&row.Key", "&row.Versions"}
+	if t.Tenant {
+		columns = append([]string{d.Quote(TenantColumn)}, columns...)
+		dests = append([]string{"&row.TenantID"}, dests...)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", strings.Join(columns, ", "), t.OutboxTable()), dests
+}
+
+// generateSQLOutboxStore generates the outbox.Store implementation of a
+// message on database/sql.
+func generateSQLOutboxStore(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table) {
+	modelName := msg.GoIdent.GoName
+	entry := outboxPackage.Ident("Entry")
+	now := timePackage.Ident("Time")
+	pending := g.QualifiedGoIdent(outboxPackage.Ident("StatusPending"))
+	querier := storePackage.Ident("Querier")
+
+	g.P("// ", modelName, "Outbox is the ", outboxPackage.Ident("Store"), " of ", modelName, " chain writes.")
+	g.P("type ", modelName, "Outbox struct {")
+	g.P("  db *sql.DB")
+	g.P("}")
+	g.P()
+	g.P("var _ ", outboxPackage.Ident("Store"), " = (*", modelName, "Outbox)(nil)")
+	g.P()
+	g.P("func New", modelName, "Outbox(db *sql.DB) *", modelName, "Outbox {")
+	g.P("  return &", modelName, "Outbox{db: db}")
+	g.P("}")
+	g.P()
+
+	query := outboxPendingQuery(d, t)
+	g.P("func (o *", modelName, "Outbox) Pending(ctx context.Context, now ", now, ", limit int) ([]", entry, ", error) {")
+	g.P("  rows, err := o.db.QueryContext(ctx, ", quotedQuery(d, query), ", ", pending, ", now, limit)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  defer rows.Close()")
+	g.P("  var entries []", entry)
+	g.P("  for rows.Next() {")
+	g.P("    var e ", entry)
+	g.P("    if err := rows.Scan(", outboxEntryScan(t), "); err != nil {")
+	g.P("      return nil, err")
+	g.P("    }")
+	g.P("    entries = append(entries, e)")
+	g.P("  }")
+	g.P("  return entries, rows.Err()")
+	g.P("}")
+	g.P()
+
+	update := func(set string) string {
+		return quotedQuery(d, outboxUpdate(t, set))
+	}
+
+	g.P("func (o *", modelName, "Outbox) MarkDelivered(ctx context.Context, id int64, txHash string) error {")
+	g.P("  return ", storePackage.Ident("Transaction"), "(ctx, o.db, func(tx *sql.Tx) error {")
+	g.P("    row, err := o.finish(ctx, tx, id, ", update("status = ?, tx_hash = ?, delivered_at = ?"), ",")
+	g.P("      ", outboxPackage.Ident("StatusDelivered"), ", txHash, ", timePackage.Ident("Now"), "(), id, ", pending, ")")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(ctx, tx, row, ", ledgerPackage.Ident("StatusConfirmed"), ", txHash)")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkRetry(ctx context.Context, id int64, cause error, next ", now, ") error {")
+	g.P("  _, err := o.finish(ctx, o.db, id, ", update("attempts = attempts + 1, last_error = ?, next_attempt_at = ?"), ",")
+	g.P("    cause.Error(), next, id, ", pending, ")")
+	g.P("  return err")
+	g.P("}")
+	g.P()
+
+	g.P("func (o *", modelName, "Outbox) MarkFailed(ctx context.Context, id int64, cause error) error {")
+	g.P("  return ", storePackage.Ident("Transaction"), "(ctx, o.db, func(tx *sql.Tx) error {")
+	g.P("    row, err := o.finish(ctx, tx, id, ", update("status = ?, attempts = attempts + 1, last_error = ?"), ",")
+	g.P("      ", outboxPackage.Ident("StatusFailed"), ", cause.Error(), id, ", pending, ")")
+	g.P("    if err != nil {")
+	g.P("      return err")
+	g.P("    }")
+	g.P("    return o.markChain(ctx, tx, row, ", ledgerPackage.Ident("StatusFailed"), ", \"\")")
+	g.P("  })")
+	g.P("}")
+	g.P()
+
+	query, dests := outboxEntryQuery(d, t)
+	g.P("// finish applies update, an UPDATE of the pending outbox entry id, and returns the entry.")
+	g.P("func (o *", modelName, "Outbox) finish(ctx context.Context, q ", querier, ", id int64, update string, args ...any) (*", modelName, "OutboxEntry, error) {")
+	g.P("  res, err := q.ExecContext(ctx, update, args...)")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  n, err := res.RowsAffected()")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  if n == 0 {")
+	g.P("    return nil, fmt.Errorf(\"no pending outbox entry %d\", id)")
+	g.P("  }")
+	g.P("  row := ", modelName, "OutboxEntry{ID: id}")
+	g.P("  if err := q.QueryRowContext(ctx, ", quotedQuery(d, query), ", id).Scan(", strings.Join(dests, ", "), "); err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return &row, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// markChain moves the chain rows carried by an outbox entry to status.")
+	g.P("func (o *", modelName, "Outbox) markChain(ctx context.Context, q ", querier, ", row *", modelName, "OutboxEntry, status ", ledgerPackage.Ident("Status"), ", txHash string) error {")
+	g.P("  var writes []", ledgerPackage.Ident("FieldVersion"), "")
+	g.P("  if err := ", jsonPackage.Ident("Unmarshal"), "([]byte(row.Versions), &writes); err != nil {")
+	g.P("    return fmt.Errorf(\"outbox entry %d: decoding versions: %w\", row.ID, err)")
+	g.P("  }")
+	if t.Tenant {
+		// The dispatcher serves every tenant; the entry names the one of the rows.
+		g.P("  return mark", modelName, "ChainWrite(ctx, q, row.TenantID, row.Key, writes, status, txHash)")
+	} else {
+		g.P("  return mark", modelName, "ChainWrite(ctx, q, row.Key, writes, status, txHash)")
+	}
+	g.P("}")
+	g.P()
+}
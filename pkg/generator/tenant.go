@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	sdm "github.com/jinuthankachan/sdm/sdmprotos"
+)
+
+const tenantPackage = protogen.GoImportPath("github.com/jinuthankachan/sdm/pkg/tenant")
+
+// TenantColumn is the column multi-tenant tables are scoped by.
+const TenantColumn = "tenant_id"
+
+// tenantCondition is the row-level security condition of multi-tenant tables.
+const tenantCondition = "tenant_id = current_setting('sdm.tenant_id', true)"
+
+// multiTenant reports whether msg is scoped by tenant.
+func multiTenant(msg *protogen.Message, opts Options) bool {
+	return opts.MultiTenant || FileMultiTenant(msg.Desc.ParentFile())
+}
+
+// FileMultiTenant reports whether file is annotated with (sdm.multi_tenant).
+func FileMultiTenant(file protoreflect.FileDescriptor) bool {
+	opts := file.Options()
+	if opts == nil || !proto.HasExtension(opts, sdm.E_MultiTenant) {
+		return false
+	}
+	v, _ := proto.GetExtension(opts, sdm.E_MultiTenant).(bool)
+	return v
+}
+
+func tenantColumn(d Dialect) Column {
+	return Column{Name: TenantColumn, Type: d.ColumnType(ColumnText, true), Constraints: "NOT NULL"}
+}
+
+func tenantColumnDefinition(d Dialect) string {
+	return columnDefinition(d, tenantColumn(d))
+}
+
+// TenantPolicies returns the statements enabling the Postgres row-level
+// security policies that restrict the rows of a multi-tenant t to the tenant
+// in the sdm.tenant_id setting. The table owner, which the repository
+// connects as, bypasses them; the repository scopes its queries itself.
+func TenantPolicies(d Dialect, t Table) []string {
+	if !t.Tenant || d.Name() != DialectPostgres {
+		return nil
+	}
+	var stmts []string
+	for _, table := range []string{t.PiiTable(), t.ChainTable(), t.OutboxTable()} {
+		policy := table + "_tenant"
+		stmts = append(stmts,
+			"ALTER TABLE "+table+" ENABLE ROW LEVEL SECURITY",
+			fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", policy, table),
+			fmt.Sprintf("CREATE POLICY %s ON %s USING (%s) WITH CHECK (%s)", policy, table, tenantCondition, tenantCondition),
+		)
+	}
+	return stmts
+}
+
+// readCondition returns the condition of the read policies of t.
+func readCondition(t Table) string {
+	if t.Tenant {
+		return tenantCondition
+	}
+	return "true"
+}
+
+// scoped returns the Go source of a condition map over entries, scoped by
+// the tenantID variable when tenant is set.
+func scoped(tenant bool, entries ...string) string {
+	if tenant {
+		entries = append([]string{fmt.Sprintf("%q: tenantID", TenantColumn)}, entries...)
+	}
+	return "map[string]interface{}{" + strings.Join(entries, ", ") + "}"
+}
+
+// generateRequireTenant generates reading the tenant of ctx into tenantID,
+// returning zero and tenant.ErrMissing without one.
+func generateRequireTenant(g *protogen.GeneratedFile, tenant bool, zero string) {
+	if !tenant {
+		return
+	}
+	ret := tenantPackage.Ident("ErrMissing")
+	g.P("  tenantID, ok := ", tenantPackage.Ident("From"), "(ctx)")
+	g.P("  if !ok {")
+	if zero != "" {
+		g.P("    return ", zero, ", ", ret)
+	} else {
+		g.P("    return ", ret)
+	}
+	g.P("  }")
+}
@@ -0,0 +1,205 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// ValidateRowVersion reports an error for a field of a message of file whose
+// column would clash with the row_version column of its PII table.
+func ValidateRowVersion(file *protogen.File) error {
+	for _, msg := range file.Messages {
+		for _, field := range msg.Fields {
+			if string(field.Desc.Name()) == RowVersionColumn || field.GoName == "RowVersion" {
+				return fmt.Errorf("%s.%s: the field name is reserved for the row version of the record", msg.Desc.Name(), field.Desc.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// updateFields returns the fields of msg Update writes to its PII row: the
+// PII columns but the primary key.
+func updateFields(msg *protogen.Message) []*protogen.Field {
+	var fields []*protogen.Field
+	for _, field := range msg.Fields {
+		if opts := getFieldOptions(field); !opts.PrimaryKey && (opts.Pii || opts.QueryIndex) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// updateQuery returns the UPDATE of the PII row of msg at a row version,
+// bumping it, and the Go source of its arguments.
+func updateQuery(msg *protogen.Message, d Dialect, t Table) (string, string) {
+	var set, args []string
+	for _, field := range updateFields(msg) {
+		set = append(set, d.Quote(string(field.Desc.Name()))+" = ?")
+		args = append(args, piiValue(field, "model."+field.GoName))
+	}
+	column := d.Quote(RowVersionColumn)
+	set = append(set, column+" = "+column+" + 1")
+	where := sqlCondition(d, t, recordKeyName(msg), RowVersionColumn) + notDeleted(d, t)
+	args = append(args, sqlArgs(t, "model."+recordKeyField(msg), "version"))
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", t.PiiTable(), strings.Join(set, ", "), where), strings.Join(args, ", ")
+}
+
+// existsQuery returns the query selecting 1 for the PII row of msg.
+func existsQuery(msg *protogen.Message, d Dialect, t Table) string {
+	return fmt.Sprintf("SELECT 1 FROM %s WHERE %s", t.PiiTable(), sqlCondition(d, t, recordKeyName(msg))+notDeleted(d, t))
+}
+
+// notDeleted returns the condition leaving out deleted PII rows of a
+// soft-delete t, led by AND, or nothing.
+func notDeleted(d Dialect, t Table) string {
+	if !t.SoftDelete {
+		return ""
+	}
+	return " AND " + d.Quote(DeletedAtColumn) + " IS NULL"
+}
+
+// generateUpdateDoc generates the doc comment of the Update of msg, whose
+// repository returns errNotFound for a missing record.
+func generateUpdateDoc(g *protogen.GeneratedFile, t Table, errNotFound string) {
+	g.P("// Update writes model over its record if the record is still at row version")
+	g.P("// version, the RowVersion of the view it was read at, bumping the row version")
+	g.P("// and appending the chain fields as Save does. It returns store.ErrConflict if")
+	if t.SoftDelete {
+		g.P("// another writer updated the record since, or ", errNotFound, " without one or")
+		g.P("// if it is deleted.")
+		return
+	}
+	g.P("// another writer updated the record since, or ", errNotFound, " without one.")
+}
+
+// generateGormUpdate generates the GORM Update of msg.
+func generateGormUpdate(g *protogen.GeneratedFile, msg *protogen.Message, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	tenant := t.Tenant
+	key := fmt.Sprintf("%q: model.%s", recordKeyName(msg), recordKeyField(msg))
+	where := ""
+	if t.SoftDelete {
+		where = fmt.Sprintf(".Where(%q)", DeletedAtColumn+" IS NULL")
+	}
+
+	generateUpdateDoc(g, t, "gorm.ErrRecordNotFound")
+	g.P("func (r *", modelName, "Repo) Update(ctx context.Context, model *", modelName, ", version int64) error {")
+	generateRequireTenant(g, tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {")
+	g.P("    res := tx.Model(&", modelName, "Pii{}).Where(", scoped(tenant, key, fmt.Sprintf("%q: version", RowVersionColumn)), ")", where, ".Updates(map[string]interface{}{")
+	for _, field := range updateFields(msg) {
+		g.P("      ", fmt.Sprintf("%q", field.Desc.Name()), ": ", piiValue(field, "model."+field.GoName), ",")
+	}
+	g.P("      ", fmt.Sprintf("%q", RowVersionColumn), ": gorm.Expr(", fmt.Sprintf("%q", RowVersionColumn+" + 1"), "),")
+	g.P("    })")
+	g.P("    if res.Error != nil { return res.Error }")
+	g.P("    if res.RowsAffected == 0 {")
+	g.P("      if err := tx.Where(", scoped(tenant, key), ")", where, ".Take(&", modelName, "Pii{}).Error; err != nil { return err }")
+	g.P("      return ", storePackage.Ident("ErrConflict"))
+	g.P("    }")
+	g.P()
+	generateGormSaveChain(g, msg, tenant, opts)
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
+
+// generateSQLUpdate generates the database/sql Update of msg.
+func generateSQLUpdate(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	query, args := updateQuery(msg, d, t)
+
+	generateUpdateDoc(g, t, "sql.ErrNoRows")
+	g.P("func (r *", modelName, "Repo) Update(ctx context.Context, model *", modelName, ", version int64) error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return ", storePackage.Ident("InTx"), "(ctx, r.db, r.tx, func(tx *sql.Tx) error {")
+	g.P("    res, err := tx.ExecContext(ctx, ", quotedQuery(d, query), ", ", args, ")")
+	g.P("    if err != nil { return err }")
+	g.P("    n, err := res.RowsAffected()")
+	g.P("    if err != nil { return err }")
+	g.P("    if n == 0 {")
+	g.P("      var exists int")
+	g.P("      if err := tx.QueryRowContext(ctx, ", quotedQuery(d, existsQuery(msg, d, t)), ", ", sqlArgs(t, "model."+recordKeyField(msg)), ").Scan(&exists); err != nil { return err }")
+	g.P("      return ", storePackage.Ident("ErrConflict"))
+	g.P("    }")
+	g.P()
+	generateSQLSaveChain(g, msg, d, t, opts)
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
+
+// generatePgxUpdate generates the pgx Update of msg. The PII row is updated
+// in a transaction of its own, or a savepoint, before the chain rows and the
+// outbox entry are sent in one batch.
+func generatePgxUpdate(g *protogen.GeneratedFile, msg *protogen.Message, d Dialect, t Table, opts Options) {
+	modelName := msg.GoIdent.GoName
+	query, args := updateQuery(msg, d, t)
+
+	generateUpdateDoc(g, t, "pgx.ErrNoRows")
+	g.P("func (r *", modelName, "Repo) Update(ctx context.Context, model *", modelName, ", version int64) error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  return ", storePackage.Ident("RetryVersionConflicts"), "(ctx, func() error {")
+	g.P("  return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {")
+	g.P("    tag, err := tx.Exec(ctx, ", quotedQuery(d, query), ", ", args, ")")
+	g.P("    if err != nil { return err }")
+	g.P("    if tag.RowsAffected() == 0 {")
+	g.P("      var exists int")
+	g.P("      if err := tx.QueryRow(ctx, ", quotedQuery(d, existsQuery(msg, d, t)), ", ", sqlArgs(t, "model."+recordKeyField(msg)), ").Scan(&exists); err != nil { return err }")
+	g.P("      return ", storePackage.Ident("ErrConflict"))
+	g.P("    }")
+	g.P()
+	generatePgxSaveBatch(g, msg, d, t, opts, "tx", "")
+	g.P("  })")
+	g.P("  })")
+	g.P("}")
+	g.P()
+}
+
+// generateFakeUpdate generates the Update of the fake of msg.
+func generateFakeUpdate(g *protogen.GeneratedFile, msg *protogen.Message, t Table, errNotFound string) {
+	fake := msg.GoIdent.GoName + "Fake"
+	tenantID := `""`
+	if t.Tenant {
+		tenantID = "tenantID"
+	}
+	key := "model." + recordKeyField(msg)
+
+	generateUpdateDoc(g, t, errNotFound)
+	g.P("func (r *", fake, ") Update(ctx context.Context, model *", msg.GoIdent.GoName, ", version int64) error {")
+	generateRequireTenant(g, t.Tenant, "")
+	g.P("  tree, err := model.MerkleTree()")
+	g.P("  if err != nil {")
+	g.P("    return err")
+	g.P("  }")
+	g.P("  r.mu.Lock()")
+	g.P("  defer r.mu.Unlock()")
+	g.P("  pii, ok := r.pii.Get(", tenantID, ", ", key, ")")
+	if t.SoftDelete {
+		g.P("  if !ok || pii.DeletedAt != nil {")
+	} else {
+		g.P("  if !ok {")
+	}
+	g.P("    return ", errNotFound)
+	g.P("  }")
+	g.P("  if pii.RowVersion != version {")
+	g.P("    return ", storePackage.Ident("ErrConflict"))
+	g.P("  }")
+	for _, field := range updateFields(msg) {
+		g.P("  pii.", field.GoName, " = ", piiValue(field, "model."+field.GoName))
+	}
+	g.P("  pii.RowVersion++")
+	g.P("  r.pii.Put(", tenantID, ", ", key, ", pii)")
+	g.P("  fields := append(model.ChainFields(), ", ledgerPackage.Ident("Field"), "{Name: ", merklePackage.Ident("RootField"), ", Value: tree.RootHex()})")
+	g.P("  r.chain.Append(", tenantID, ", ", key, ", fields, ", timePackage.Ident("Now"), "())")
+	g.P("  return nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,89 @@
+package generator
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// generateVerifyMethods generates the repository methods checking plaintext
+// values against the latest published hashes of hashed fields.
+func generateVerifyMethods(g *protogen.GeneratedFile, msg *protogen.Message, tenant bool) {
+	modelName := msg.GoIdent.GoName
+	hashed := hashedFields(msg)
+
+	g.P("// publishedHashes returns the latest published hash of every hashed field of")
+	g.P("// the record, keyed by chain field name.")
+	g.P("func (r *", modelName, "Repo) publishedHashes(ctx context.Context, key string) (map[string]string, error) {")
+	generateRequireTenant(g, tenant, "nil")
+	g.P("  names := []string{")
+	for _, field := range hashed {
+		g.P("    \"hashed_", field.Desc.Name(), "\",")
+	}
+	g.P("  }")
+	g.P("  var rows []", modelName, "Chain")
+	g.P("  err := r.db.WithContext(ctx).")
+	g.P("    Where(", scoped(tenant, `"key": key`, `"field_name": names`), ").")
+	g.P("    Order(\"version DESC\").Find(&rows).Error")
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  published := make(map[string]string, len(names))")
+	g.P("  for _, row := range rows {")
+	g.P("    if _, ok := published[row.FieldName]; !ok {")
+	g.P("      published[row.FieldName] = row.FieldValue")
+	g.P("    }")
+	g.P("  }")
+	g.P("  return published, nil")
+	g.P("}")
+	g.P()
+
+	generateHashVerifyMethods(g, msg, modelName+"Repo")
+}
+
+// hashedFields returns the fields of msg annotated with (sdm.hashed).
+func hashedFields(msg *protogen.Message) []*protogen.Field {
+	var hashed []*protogen.Field
+	for _, field := range msg.Fields {
+		if getFieldOptions(field).Hashed {
+			hashed = append(hashed, field)
+		}
+	}
+	return hashed
+}
+
+// generateHashVerifyMethods generates Verify<Field> and VerifyRecord on top
+// of the publishedHashes of any backend, as methods of recv.
+func generateHashVerifyMethods(g *protogen.GeneratedFile, msg *protogen.Message, recv string) {
+	modelName := msg.GoIdent.GoName
+
+	for _, field := range hashedFields(msg) {
+		g.P("// Verify", field.GoName, " reports whether candidate matches the latest published hash of ", field.Desc.Name(), ".")
+		g.P("func (r *", recv, ") Verify", field.GoName, "(ctx context.Context, id string, candidate ", goTypeForField(field), ") (bool, error) {")
+		g.P("  published, err := r.publishedHashes(ctx, id)")
+		g.P("  if err != nil {")
+		g.P("    return false, err")
+		g.P("  }")
+		g.P("  hash, ok := published[\"hashed_", field.Desc.Name(), "\"]")
+		g.P("  if !ok {")
+		g.P("    return false, fmt.Errorf(\"no published hash of ", field.Desc.Name(), " for %q\", id)")
+		g.P("  }")
+		g.P("  return ", ledgerPackage.Ident("Hash"), "(fmt.Sprintf(\"%v\", candidate)) == hash, nil")
+		g.P("}")
+		g.P()
+	}
+
+	pk := primaryKeyField(msg)
+	g.P("// VerifyRecord checks every hashed field of model against its latest published")
+	g.P("// hash and returns the fields that do not match.")
+	g.P("func (r *", recv, ") VerifyRecord(ctx context.Context, model *", modelName, ") ([]", ledgerPackage.Ident("Mismatch"), ", error) {")
+	if pk != nil {
+		g.P("  published, err := r.publishedHashes(ctx, fmt.Sprintf(\"%v\", model.Get", pk.GoName, "()))")
+	} else {
+		g.P("  published, err := r.publishedHashes(ctx, \"\")")
+	}
+	g.P("  if err != nil {")
+	g.P("    return nil, err")
+	g.P("  }")
+	g.P("  return ", ledgerPackage.Ident("CompareHashes"), "(model.ChainFields(), published), nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,35 @@
+// Package ledger holds the runtime types shared by SDM generated code for
+// tracking how chain fields are anchored on a ledger.
+package ledger
+
+// Status is the ledger state of a chain row.
+type Status string
+
+const (
+	// StatusPending marks a chain row that has not been handed to the ledger yet.
+	StatusPending Status = "pending"
+	// StatusSubmitted marks a chain row that is part of a submitted, unconfirmed transaction.
+	StatusSubmitted Status = "submitted"
+	// StatusConfirmed marks a chain row that landed on the ledger.
+	StatusConfirmed Status = "confirmed"
+	// StatusFailed marks a chain row whose ledger submission failed.
+	StatusFailed Status = "failed"
+)
+
+// Valid reports whether s is one of the known statuses.
+func (s Status) Valid() bool {
+	switch s {
+	case StatusPending, StatusSubmitted, StatusConfirmed, StatusFailed:
+		return true
+	}
+	return false
+}
+
+// FieldVersion identifies a chain row of a record: a version of one of its
+// fields. Versions count per field, so the rows written together, by a Save
+// or a Delete, are named by the pairs of their fields and versions, which
+// outbox entries carry to mark the rows they deliver.
+type FieldVersion struct {
+	Field   string `json:"field"`
+	Version int64  `json:"version"`
+}
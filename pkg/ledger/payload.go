@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Field is a chain field of a record, as written to the chain table.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TombstoneField is the chain field name under which the repositories of
+// soft-delete messages record the deletion of a record: the time it was
+// deleted at, in RFC 3339, or empty once it is restored.
+const TombstoneField = "_deleted"
+
+// Hash returns the hash SDM publishes for the value of a hashed field: the
+// hex encoded SHA-256 of the value.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+type payload struct {
+	Type     string  `json:"type"`
+	TenantID string  `json:"tenant_id,omitempty"`
+	Key      string  `json:"key"`
+	Fields   []Field `json:"fields"`
+}
+
+// Payload returns the canonical encoding of the chain fields of a record of
+// the given proto message type: compact JSON of the form
+//
+//	{"type":"pkg.Message","key":"...","fields":[{"name":"...","value":"..."},...]}
+//
+// with the fields sorted by name and no HTML escaping, so the same record
+// always encodes to the same bytes.
+func Payload(typ, key string, fields []Field) ([]byte, error) {
+	return TenantPayload(typ, "", key, fields)
+}
+
+// TenantPayload returns the canonical encoding of the chain fields of a
+// record of a tenant, as Payload does with the tenant after the type:
+//
+//	{"type":"pkg.Message","tenant_id":"...","key":"...","fields":[...]}
+//
+// Keys are only unique within a tenant, so the payloads of multi-tenant
+// messages carry it. An empty tenantID encodes as Payload does.
+func TenantPayload(typ, tenantID, key string, fields []Field) ([]byte, error) {
+	sorted := append([]Field(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Name == sorted[i-1].Name {
+			return nil, fmt.Errorf("duplicate chain field %q", sorted[i].Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(payload{Type: typ, TenantID: tenantID, Key: key, Fields: sorted}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Digest returns the record digest of a canonical payload: the hex encoded
+// SHA-256 of its bytes.
+func Digest(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
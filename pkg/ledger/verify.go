@@ -0,0 +1,41 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+)
+
+// HashedPrefix prefixes the chain field holding the hash of a hashed field.
+const HashedPrefix = "hashed_"
+
+// Mismatch describes a hashed field whose value does not match its published hash.
+type Mismatch struct {
+	// Field is the name of the hashed field, without HashedPrefix.
+	Field string
+	// Published is the published hash, empty if none was published.
+	Published string
+	// Computed is the hash of the candidate value.
+	Computed string
+}
+
+// CompareHashes compares the hash companions among fields, as returned by a
+// generated ChainFields method, with the published hashes keyed by chain field
+// name. It returns one Mismatch per hash that differs or is not published,
+// ordered by field name.
+func CompareHashes(fields []Field, published map[string]string) []Mismatch {
+	var mismatches []Mismatch
+	for _, f := range fields {
+		if !strings.HasPrefix(f.Name, HashedPrefix) {
+			continue
+		}
+		if p, ok := published[f.Name]; !ok || p != f.Value {
+			mismatches = append(mismatches, Mismatch{
+				Field:     strings.TrimPrefix(f.Name, HashedPrefix),
+				Published: p,
+				Computed:  f.Value,
+			})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Field < mismatches[j].Field })
+	return mismatches
+}
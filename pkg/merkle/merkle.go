@@ -0,0 +1,151 @@
+// Package merkle commits to the chain fields of a record with a Merkle tree,
+// so that a single root can be published per record version and any one
+// field disclosed later with an inclusion proof.
+//
+// Leaves are the fields sorted by name. A leaf hashes to
+// SHA-256(0x00 || name || 0x00 || value) and an inner node to
+// SHA-256(0x01 || left || right); the last node of an odd level is promoted
+// to the next level unchanged.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+)
+
+// RootField is the chain field name under which SDM generated repositories
+// store the Merkle root of every record version.
+const RootField = "_merkle_root"
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Tree is a Merkle tree over the chain fields of a record.
+type Tree struct {
+	fields []ledger.Field
+	// levels[0] holds the leaf hashes, the last level holds the root.
+	levels [][][]byte
+}
+
+// New builds the tree of fields. Field names must be unique.
+func New(fields []ledger.Field) (*Tree, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("merkle: no fields")
+	}
+	sorted := append([]ledger.Field(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	level := make([][]byte, len(sorted))
+	for i, f := range sorted {
+		if i > 0 && f.Name == sorted[i-1].Name {
+			return nil, fmt.Errorf("merkle: duplicate field %q", f.Name)
+		}
+		level[i] = leafHash(f)
+	}
+
+	t := &Tree{fields: sorted, levels: [][][]byte{level}}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t, nil
+}
+
+// Root returns the root hash of the tree.
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// RootHex returns the hex encoded root hash of the tree.
+func (t *Tree) RootHex() string {
+	return hex.EncodeToString(t.Root())
+}
+
+// Step is one sibling hash on the path from a leaf to the root.
+type Step struct {
+	// Hash is the hex encoded sibling hash.
+	Hash string `json:"hash"`
+	// Left reports whether the sibling is the left operand of the parent node.
+	Left bool `json:"left"`
+}
+
+// Proof discloses one field of a record and proves its inclusion under a root.
+type Proof struct {
+	Field ledger.Field `json:"field"`
+	Steps []Step       `json:"steps"`
+}
+
+// Prove returns the inclusion proof of the named field.
+func (t *Tree) Prove(name string) (*Proof, error) {
+	index := sort.Search(len(t.fields), func(i int) bool { return t.fields[i].Name >= name })
+	if index == len(t.fields) || t.fields[index].Name != name {
+		return nil, fmt.Errorf("merkle: no field %q", name)
+	}
+
+	proof := &Proof{Field: t.fields[index]}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, Step{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Verify reports whether the proof is valid against the hex encoded root.
+func (p *Proof) Verify(rootHex string) bool {
+	root, err := hex.DecodeString(rootHex)
+	if err != nil {
+		return false
+	}
+	hash := leafHash(p.Field)
+	for _, step := range p.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			hash = nodeHash(sibling, hash)
+		} else {
+			hash = nodeHash(hash, sibling)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+func leafHash(f ledger.Field) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write([]byte(f.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(f.Value))
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
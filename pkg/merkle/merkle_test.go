@@ -0,0 +1,142 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+)
+
+func fields(n int) []ledger.Field {
+	fs := make([]ledger.Field, n)
+	for i := range fs {
+		// Out of name order, which New sorts.
+		fs[i] = ledger.Field{Name: fmt.Sprintf("f%02d", n-1-i), Value: fmt.Sprintf("v%d", i)}
+	}
+	return fs
+}
+
+func hexHash(b []byte) string { return hex.EncodeToString(b) }
+
+func TestRoot(t *testing.T) {
+	a := ledger.Field{Name: "a", Value: "1"}
+	b := ledger.Field{Name: "b", Value: "2"}
+	c := ledger.Field{Name: "c", Value: "3"}
+	tests := []struct {
+		name   string
+		fields []ledger.Field
+		root   []byte
+	}{
+		{name: "one leaf", fields: []ledger.Field{a}, root: leafHash(a)},
+		{name: "two leaves", fields: []ledger.Field{b, a}, root: nodeHash(leafHash(a), leafHash(b))},
+		// The odd leaf is promoted unchanged.
+		{name: "three leaves", fields: []ledger.Field{c, a, b}, root: nodeHash(nodeHash(leafHash(a), leafHash(b)), leafHash(c))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := New(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tree.RootHex(), hexHash(tt.root); got != want {
+				t.Errorf("RootHex() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestLeafHashSeparatesNameAndValue(t *testing.T) {
+	x := leafHash(ledger.Field{Name: "ab", Value: "c"})
+	y := leafHash(ledger.Field{Name: "a", Value: "bc"})
+	if hexHash(x) == hexHash(y) {
+		t.Error("fields with the same concatenated name and value hash alike")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("New() of no fields succeeded")
+	}
+	if _, err := New([]ledger.Field{{Name: "a"}, {Name: "b"}, {Name: "a"}}); err == nil {
+		t.Error("New() of a duplicate field succeeded")
+	}
+}
+
+func TestProve(t *testing.T) {
+	// 1, 2 and 3 leaves, and 2^n+1 leaves, whose last leaf is promoted up
+	// every level but the last.
+	for _, n := range []int{1, 2, 3, 4, 5, 8, 9, 17} {
+		t.Run(fmt.Sprintf("%d leaves", n), func(t *testing.T) {
+			fs := fields(n)
+			tree, err := New(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := tree.RootHex()
+			for _, f := range fs {
+				proof, err := tree.Prove(f.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if proof.Field != f {
+					t.Errorf("Prove(%s) discloses %+v", f.Name, proof.Field)
+				}
+				if !proof.Verify(root) {
+					t.Errorf("proof of %s does not verify", f.Name)
+				}
+			}
+			if _, err := tree.Prove("missing"); err == nil {
+				t.Error("Prove() of a missing field succeeded")
+			}
+		})
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tree, err := New(fields(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.RootHex()
+	otherTree, err := New(fields(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(h string) string {
+		b, _ := hex.DecodeString(h)
+		b[0] ^= 1
+		return hex.EncodeToString(b)
+	}
+	tests := []struct {
+		name   string
+		field  string
+		tamper func(p *Proof) string
+	}{
+		{name: "value", field: "f03", tamper: func(p *Proof) string { p.Field.Value += "x"; return root }},
+		{name: "name", field: "f03", tamper: func(p *Proof) string { p.Field.Name = "f04"; return root }},
+		{name: "sibling", field: "f03", tamper: func(p *Proof) string { p.Steps[0].Hash = flip(p.Steps[0].Hash); return root }},
+		{name: "promoted sibling", field: "f08", tamper: func(p *Proof) string { p.Steps[0].Hash = flip(p.Steps[0].Hash); return root }},
+		{name: "side", field: "f03", tamper: func(p *Proof) string { p.Steps[1].Left = !p.Steps[1].Left; return root }},
+		{name: "dropped step", field: "f03", tamper: func(p *Proof) string { p.Steps = p.Steps[:len(p.Steps)-1]; return root }},
+		{name: "bad sibling hex", field: "f03", tamper: func(p *Proof) string { p.Steps[0].Hash = "zz"; return root }},
+		{name: "root", field: "f03", tamper: func(p *Proof) string { return flip(root) }},
+		{name: "root of another tree", field: "f03", tamper: func(p *Proof) string { return otherTree.RootHex() }},
+		{name: "bad root hex", field: "f03", tamper: func(p *Proof) string { return "not hex" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof, err := tree.Prove(tt.field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proof.Verify(root) {
+				t.Fatal("untampered proof does not verify")
+			}
+			if proof.Verify(tt.tamper(proof)) {
+				t.Error("tampered proof verifies")
+			}
+		})
+	}
+}
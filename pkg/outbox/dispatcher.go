@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Dispatcher is a polling Publisher. It is not safe to run several
+// dispatchers over the same stores.
+type Dispatcher struct {
+	Client LedgerClient
+	Stores []Store
+
+	// Interval between two polls in Run.
+	Interval time.Duration
+	// BatchSize is the maximum number of entries taken from a store per poll.
+	BatchSize int
+	// MaxAttempts is the number of submissions after which an entry is marked failed.
+	MaxAttempts int
+	// Backoff returns the delay before the given attempt (starting at 1) is retried.
+	Backoff func(attempt int) time.Duration
+	// OnError, when set, is called with the errors Run swallows to keep polling.
+	OnError func(error)
+
+	now func() time.Time
+}
+
+var _ Publisher = (*Dispatcher)(nil)
+
+// NewDispatcher returns a Dispatcher delivering the entries of stores to client
+// with default polling and retry settings.
+func NewDispatcher(client LedgerClient, stores ...Store) *Dispatcher {
+	return &Dispatcher{
+		Client:      client,
+		Stores:      stores,
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 10,
+		Backoff:     ExponentialBackoff(time.Second, 5*time.Minute),
+		now:         time.Now,
+	}
+}
+
+// ExponentialBackoff returns a backoff doubling from base up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// Run polls the stores every Interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Publish(ctx); err != nil && d.OnError != nil {
+			d.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish submits the pending entries of every store once. Submission
+// failures are scheduled for retry and are not returned; errors from the
+// stores are.
+func (d *Dispatcher) Publish(ctx context.Context) (int, error) {
+	delivered := 0
+	var errs []error
+	for _, store := range d.Stores {
+		n, err := d.publishStore(ctx, store)
+		delivered += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (d *Dispatcher) publishStore(ctx context.Context, store Store) (int, error) {
+	entries, err := store.Pending(ctx, d.clock(), d.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("loading pending outbox entries: %w", err)
+	}
+
+	delivered := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		txHash, err := d.Client.Submit(ctx, entry)
+		if err == nil {
+			if err := store.MarkDelivered(ctx, entry.ID, txHash); err != nil {
+				return delivered, fmt.Errorf("marking outbox entry %d delivered: %w", entry.ID, err)
+			}
+			delivered++
+			continue
+		}
+
+		attempt := entry.Attempts + 1
+		if attempt >= d.MaxAttempts {
+			if err := store.MarkFailed(ctx, entry.ID, err); err != nil {
+				return delivered, fmt.Errorf("marking outbox entry %d failed: %w", entry.ID, err)
+			}
+			continue
+		}
+		if err := store.MarkRetry(ctx, entry.ID, err, d.clock().Add(d.Backoff(attempt))); err != nil {
+			return delivered, fmt.Errorf("scheduling retry of outbox entry %d: %w", entry.ID, err)
+		}
+	}
+	return delivered, nil
+}
+
+func (d *Dispatcher) clock() time.Time {
+	if d.now == nil {
+		return time.Now()
+	}
+	return d.now()
+}
@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// memStore is a Store over entries in memory.
+type memStore struct {
+	entries map[int64]*memEntry
+}
+
+type memEntry struct {
+	Entry
+	status    string
+	next      time.Time
+	lastError string
+	txHash    string
+}
+
+func newMemStore(entries ...Entry) *memStore {
+	s := &memStore{entries: make(map[int64]*memEntry)}
+	for _, e := range entries {
+		s.entries[e.ID] = &memEntry{Entry: e, status: StatusPending}
+	}
+	return s
+}
+
+func (s *memStore) Pending(ctx context.Context, now time.Time, limit int) ([]Entry, error) {
+	var entries []Entry
+	for _, e := range s.entries {
+		if e.status == StatusPending && !e.next.After(now) {
+			entries = append(entries, e.Entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *memStore) pending(id int64) (*memEntry, error) {
+	e, ok := s.entries[id]
+	if !ok || e.status != StatusPending {
+		return nil, errors.New("no pending outbox entry")
+	}
+	return e, nil
+}
+
+func (s *memStore) MarkDelivered(ctx context.Context, id int64, txHash string) error {
+	e, err := s.pending(id)
+	if err != nil {
+		return err
+	}
+	e.status, e.txHash = StatusDelivered, txHash
+	return nil
+}
+
+func (s *memStore) MarkRetry(ctx context.Context, id int64, cause error, next time.Time) error {
+	e, err := s.pending(id)
+	if err != nil {
+		return err
+	}
+	e.Attempts++
+	e.lastError, e.next = cause.Error(), next
+	return nil
+}
+
+func (s *memStore) MarkFailed(ctx context.Context, id int64, cause error) error {
+	e, err := s.pending(id)
+	if err != nil {
+		return err
+	}
+	e.Attempts++
+	e.status, e.lastError = StatusFailed, cause.Error()
+	return nil
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	errLedger := errors.New("ledger unavailable")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		failures    int
+		maxAttempts int
+		status      string
+		attempts    int
+		// delays are the backoffs the retries are scheduled with.
+		delays []time.Duration
+	}{
+		{name: "delivered", failures: 0, maxAttempts: 3, status: StatusDelivered},
+		{name: "delivered after retries", failures: 2, maxAttempts: 3, status: StatusDelivered, attempts: 2, delays: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "failed after max attempts", failures: 5, maxAttempts: 3, status: StatusFailed, attempts: 3, delays: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "failed at once", failures: 1, maxAttempts: 1, status: StatusFailed, attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			ledger := NewMemoryLedger()
+			store := newMemStore(Entry{ID: 1, Key: "inv_1", Payload: []byte(`{"a":1}`)})
+			d := NewDispatcher(ledger, store)
+			d.MaxAttempts = tt.maxAttempts
+			d.Backoff = ExponentialBackoff(time.Second, time.Minute)
+			now := start
+			d.now = func() time.Time { return now }
+
+			var delays []time.Duration
+			delivered := 0
+			for pass := 0; store.entries[1].status == StatusPending; pass++ {
+				if pass > tt.failures+tt.maxAttempts {
+					t.Fatalf("entry still pending after %d passes", pass)
+				}
+				if pass < tt.failures {
+					ledger.FailWith(errLedger)
+				} else {
+					ledger.FailWith(nil)
+				}
+				n, err := d.Publish(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				delivered += n
+				e := store.entries[1]
+				if e.status != StatusPending {
+					break
+				}
+				delays = append(delays, e.next.Sub(now))
+
+				// The entry is not due before its next attempt.
+				now = e.next.Add(-time.Nanosecond)
+				if n, err := d.Publish(ctx); err != nil || n != 0 || store.entries[1].Attempts != e.Attempts {
+					t.Fatalf("pass %d: entry submitted before its next attempt", pass)
+				}
+				now = e.next
+			}
+
+			e := store.entries[1]
+			if e.status != tt.status || e.Attempts != tt.attempts {
+				t.Errorf("entry is %s after %d attempts, want %s after %d", e.status, e.Attempts, tt.status, tt.attempts)
+			}
+			if len(delays) != len(tt.delays) {
+				t.Fatalf("retries scheduled after %v, want %v", delays, tt.delays)
+			}
+			for i := range delays {
+				if delays[i] != tt.delays[i] {
+					t.Errorf("retries scheduled after %v, want %v", delays, tt.delays)
+					break
+				}
+			}
+			submitted := ledger.Entries()
+			switch tt.status {
+			case StatusDelivered:
+				if delivered != 1 || len(submitted) != 1 || e.txHash == "" {
+					t.Errorf("delivered entry: Publish() delivered %d, %d submissions, tx hash %q", delivered, len(submitted), e.txHash)
+				}
+			case StatusFailed:
+				if delivered != 0 || len(submitted) != 0 || e.txHash != "" || e.lastError != errLedger.Error() {
+					t.Errorf("failed entry: Publish() delivered %d, %d submissions, tx hash %q, last error %q", delivered, len(submitted), e.txHash, e.lastError)
+				}
+			}
+		})
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 10*time.Second)
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 8 * time.Second},
+		{attempt: 5, want: 10 * time.Second},
+		{attempt: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// MemoryLedger is a LedgerClient keeping submitted entries in memory, for tests.
+type MemoryLedger struct {
+	mu      sync.Mutex
+	entries []Entry
+	err     error
+}
+
+// NewMemoryLedger returns an empty MemoryLedger.
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{}
+}
+
+// Submit records the entry and returns a transaction hash derived from its
+// position and payload.
+func (l *MemoryLedger) Submit(ctx context.Context, entry Entry) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return "", l.err
+	}
+	l.entries = append(l.entries, entry)
+	return txHash(len(l.entries), entry.Payload), nil
+}
+
+// FailWith makes subsequent submissions fail with err; a nil err restores them.
+func (l *MemoryLedger) FailWith(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+// Entries returns the submitted entries in submission order.
+func (l *MemoryLedger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Entry(nil), l.entries...)
+}
+
+// FileLedger is a LedgerClient appending submitted entries to a local file as
+// JSON lines, for tests and local development.
+type FileLedger struct {
+	mu   sync.Mutex
+	path string
+	seq  int
+}
+
+// NewFileLedger returns a FileLedger appending to the file at path.
+func NewFileLedger(path string) *FileLedger {
+	return &FileLedger{path: path}
+}
+
+type fileLedgerRecord struct {
+	TxHash      string    `json:"tx_hash"`
+	ID          int64     `json:"id"`
+	Key         string    `json:"key"`
+	Payload     []byte    `json:"payload"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Submit appends the entry to the file.
+func (l *FileLedger) Submit(ctx context.Context, entry Entry) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	l.seq++
+	record := fileLedgerRecord{
+		TxHash:      txHash(l.seq, entry.Payload),
+		ID:          entry.ID,
+		Key:         entry.Key,
+		Payload:     entry.Payload,
+		SubmittedAt: time.Now().UTC(),
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", err
+	}
+	return record.TxHash, nil
+}
+
+func txHash(seq int, payload []byte) string {
+	h := sha256.New()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(seq))
+	h.Write(buf[:])
+	h.Write(payload)
+	return "0x" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,56 @@
+// Package outbox delivers chain writes queued by SDM generated repositories
+// to a ledger.
+//
+// Save writes the chain fields of a record and an outbox entry holding their
+// payload in the same transaction. A Dispatcher polls the outbox of every
+// message type, submits pending entries to a LedgerClient, retries failed
+// submissions with backoff and marks entries delivered.
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+// Outbox entry statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Entry is a chain write waiting to be delivered to the ledger.
+type Entry struct {
+	ID int64
+	// TenantID is the tenant of the record of a multi-tenant message, and
+	// empty otherwise.
+	TenantID string
+	Key      string
+	Payload  []byte
+	Attempts int
+}
+
+// LedgerClient submits outbox payloads to a ledger and returns the hash of
+// the transaction they landed in.
+type LedgerClient interface {
+	Submit(ctx context.Context, entry Entry) (txHash string, err error)
+}
+
+// Store is the outbox of one message type. It is implemented by the
+// generated <Message>Outbox types.
+type Store interface {
+	// Pending returns up to limit pending entries due at or before now, oldest first.
+	Pending(ctx context.Context, now time.Time, limit int) ([]Entry, error)
+	// MarkDelivered marks the entry delivered in txHash and confirms its chain rows.
+	MarkDelivered(ctx context.Context, id int64, txHash string) error
+	// MarkRetry records a failed attempt and schedules the next one at next.
+	MarkRetry(ctx context.Context, id int64, cause error, next time.Time) error
+	// MarkFailed records a failed attempt and gives up on the entry.
+	MarkFailed(ctx context.Context, id int64, cause error) error
+}
+
+// Publisher delivers pending outbox entries to a ledger.
+type Publisher interface {
+	// Publish makes one delivery pass and returns the number of delivered entries.
+	Publish(ctx context.Context) (int, error)
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// DefaultPageSize is the number of records List returns unless
+// ListOptions.Limit sets another.
+const DefaultPageSize = 100
+
+// Op is the comparison of a Filter.
+type Op string
+
+const (
+	OpEq  Op = "="
+	OpLt  Op = "<"
+	OpLte Op = "<="
+	OpGt  Op = ">"
+	OpGte Op = ">="
+	OpIn  Op = "IN"
+)
+
+// Filter keeps the records whose column compares to Values with Op. Build
+// filters with the methods of the generated Field values.
+type Filter struct {
+	Column string
+	Op     Op
+	Values []any
+}
+
+// Field is a column of a view that List filters by, of Go type T.
+type Field[T any] struct {
+	Column string
+}
+
+func (f Field[T]) filter(op Op, values ...T) Filter {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return Filter{Column: f.Column, Op: op, Values: args}
+}
+
+// Eq keeps the records whose field equals v.
+func (f Field[T]) Eq(v T) Filter { return f.filter(OpEq, v) }
+
+// Lt keeps the records whose field is less than v.
+func (f Field[T]) Lt(v T) Filter { return f.filter(OpLt, v) }
+
+// Lte keeps the records whose field is at most v.
+func (f Field[T]) Lte(v T) Filter { return f.filter(OpLte, v) }
+
+// Gt keeps the records whose field is greater than v.
+func (f Field[T]) Gt(v T) Filter { return f.filter(OpGt, v) }
+
+// Gte keeps the records whose field is at least v.
+func (f Field[T]) Gte(v T) Filter { return f.filter(OpGte, v) }
+
+// In keeps the records whose field is one of values.
+func (f Field[T]) In(values ...T) Filter { return f.filter(OpIn, values...) }
+
+// ListOptions selects a page of the records List returns, in the order of
+// their primary key.
+type ListOptions struct {
+	// Filters keep the records matching all of them.
+	Filters []Filter
+	// Descending orders the records by descending primary key.
+	Descending bool
+	// Limit is the size of the page, DefaultPageSize when < 1.
+	Limit int
+	// PageToken is the token returned with the previous page, or empty for
+	// the first page.
+	PageToken string
+}
+
+// PageSize returns the size of the page o selects.
+func (o ListOptions) PageSize() int {
+	if o.Limit < 1 {
+		return DefaultPageSize
+	}
+	return o.Limit
+}
+
+// After returns the primary key the page o selects starts after, or false
+// for the first page.
+func (o ListOptions) After() (string, bool, error) {
+	if o.PageToken == "" {
+		return "", false, nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(o.PageToken)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid page token %q", o.PageToken)
+	}
+	return string(key), true, nil
+}
+
+// PageToken returns the token of the page after the record of key.
+func PageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// Page cuts rows, read with a limit of one more than the page size of opts,
+// to the page opts selects, and returns the token of the next page, or an
+// empty token after the last page. key returns the primary key of a row.
+func Page[T any](rows []T, opts ListOptions, key func(T) string) ([]T, string) {
+	size := opts.PageSize()
+	if len(rows) <= size {
+		return rows, ""
+	}
+	rows = rows[:size]
+	return rows, PageToken(key(rows[size-1]))
+}
+
+// ListQuery builds the queries of the List of the database/sql and pgx
+// repositories.
+type ListQuery struct {
+	// Select is the SELECT of the view columns, without its WHERE clause.
+	Select string
+	// Key is the quoted primary key column pages are ordered by.
+	Key string
+	// Columns maps the columns filters may compare to their quoted names.
+	Columns map[string]string
+	// Numbered is set for dialects with numbered placeholders.
+	Numbered bool
+}
+
+// Build returns the query of the page opts selects, with one row more than
+// its page size, and its arguments. cond, when not empty, is a condition
+// every row matches, with the placeholders of args.
+func (q ListQuery) Build(opts ListOptions, cond string, args ...any) (string, []any, error) {
+	after, paged, err := opts.After()
+	if err != nil {
+		return "", nil, err
+	}
+	var conds []string
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	where, whereArgs, err := Where(opts.Filters, q.Columns, q.Numbered, len(args)+1)
+	if err != nil {
+		return "", nil, err
+	}
+	if where != "" {
+		conds = append(conds, where)
+		args = append(args, whereArgs...)
+	}
+	cmp, order := ">", "ASC"
+	if opts.Descending {
+		cmp, order = "<", "DESC"
+	}
+	if paged {
+		args = append(args, after)
+		conds = append(conds, fmt.Sprintf("%s %s %s", q.Key, cmp, Placeholders(q.Numbered, len(args), 1)))
+	}
+	query := q.Select
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, opts.PageSize()+1)
+	query += fmt.Sprintf(" ORDER BY %s %s LIMIT %s", q.Key, order, Placeholders(q.Numbered, len(args), 1))
+	return query, args, nil
+}
+
+// Where returns the conditions of filters joined by AND, and their
+// arguments. Placeholders are numbered from first when numbered, and ?
+// otherwise. columns maps the columns filters may compare to their quoted
+// names; a filter on any other column is an error.
+func Where(filters []Filter, columns map[string]string, numbered bool, first int) (string, []any, error) {
+	var conds []string
+	var args []any
+	for _, f := range filters {
+		column, ok := columns[f.Column]
+		if !ok {
+			return "", nil, fmt.Errorf("cannot filter by %q", f.Column)
+		}
+		switch f.Op {
+		case OpEq, OpLt, OpLte, OpGt, OpGte:
+			if len(f.Values) != 1 {
+				return "", nil, fmt.Errorf("filter %s %s: want 1 value, got %d", f.Column, f.Op, len(f.Values))
+			}
+			conds = append(conds, fmt.Sprintf("%s %s %s", column, f.Op, Placeholders(numbered, first+len(args), 1)))
+		case OpIn:
+			if len(f.Values) == 0 {
+				// Nothing is in an empty list.
+				conds = append(conds, "1 = 0")
+				continue
+			}
+			conds = append(conds, fmt.Sprintf("%s IN (%s)", column, Placeholders(numbered, first+len(args), len(f.Values))))
+		default:
+			return "", nil, fmt.Errorf("filter %s: unknown operator %q", f.Column, f.Op)
+		}
+		args = append(args, f.Values...)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// Match reports whether value, the field of a record a filter compares,
+// matches f, as Where does for the fakes. value is a string or an int64.
+func Match(f Filter, value any) (bool, error) {
+	cmp := func(v any) (int, error) {
+		switch a := value.(type) {
+		case string:
+			b, ok := v.(string)
+			if !ok {
+				return 0, fmt.Errorf("filter %s: %T value for a string field", f.Column, v)
+			}
+			return strings.Compare(a, b), nil
+		case int64:
+			b, ok := v.(int64)
+			if !ok {
+				return 0, fmt.Errorf("filter %s: %T value for an int64 field", f.Column, v)
+			}
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return 0, fmt.Errorf("filter %s: unsupported field type %T", f.Column, value)
+	}
+
+	if f.Op == OpIn {
+		for _, v := range f.Values {
+			c, err := cmp(v)
+			if err != nil || c == 0 {
+				return err == nil, err
+			}
+		}
+		return false, nil
+	}
+	if len(f.Values) != 1 {
+		return false, fmt.Errorf("filter %s %s: want 1 value, got %d", f.Column, f.Op, len(f.Values))
+	}
+	c, err := cmp(f.Values[0])
+	if err != nil {
+		return false, err
+	}
+	switch f.Op {
+	case OpEq:
+		return c == 0, nil
+	case OpLt:
+		return c < 0, nil
+	case OpLte:
+		return c <= 0, nil
+	case OpGt:
+		return c > 0, nil
+	case OpGte:
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("filter %s: unknown operator %q", f.Column, f.Op)
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageToken(t *testing.T) {
+	for _, key := range []string{"inv_1", "a/b+c=d", "ключ", "with space", "\x00\xff"} {
+		token := PageToken(key)
+		if strings.ContainsAny(token, "+/=") {
+			t.Errorf("PageToken(%q) = %q, not URL safe", key, token)
+		}
+		after, paged, err := ListOptions{PageToken: token}.After()
+		if err != nil || !paged || after != key {
+			t.Errorf("After() of PageToken(%q) = %q, %v, %v; want %q, true, nil", key, after, paged, err, key)
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		token   string
+		after   string
+		paged   bool
+		wantErr bool
+	}{
+		{token: "", paged: false},
+		{token: "aW52XzE", after: "inv_1", paged: true},
+		{token: "!!", wantErr: true},
+		{token: "aW52XzE=", wantErr: true},
+		{token: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		after, paged, err := ListOptions{PageToken: tt.token}.After()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("After() of %q: error %v, want error %v", tt.token, err, tt.wantErr)
+			continue
+		}
+		if after != tt.after || paged != tt.paged {
+			t.Errorf("After() of %q = %q, %v; want %q, %v", tt.token, after, paged, tt.after, tt.paged)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	key := func(s string) string { return s }
+	tests := []struct {
+		name  string
+		rows  []string
+		limit int
+		page  []string
+		next  string
+	}{
+		{name: "empty", rows: nil, limit: 2, page: nil},
+		{name: "short", rows: []string{"a"}, limit: 2, page: []string{"a"}},
+		{name: "exactly full", rows: []string{"a", "b"}, limit: 2, page: []string{"a", "b"}},
+		{name: "one more", rows: []string{"a", "b", "c"}, limit: 2, page: []string{"a", "b"}, next: PageToken("b")},
+		{name: "limit 1", rows: []string{"a", "b"}, limit: 1, page: []string{"a"}, next: PageToken("a")},
+		{name: "default size", rows: make([]string, DefaultPageSize+1), limit: 0, page: make([]string, DefaultPageSize), next: PageToken("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, next := Page(tt.rows, ListOptions{Limit: tt.limit}, key)
+			if !reflect.DeepEqual(page, tt.page) || next != tt.next {
+				t.Errorf("Page() = %q, %q; want %q, %q", page, next, tt.page, tt.next)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	status := Field[string]{Column: "status"}
+	amount := Field[int64]{Column: "amount"}
+	tests := []struct {
+		name    string
+		filter  Filter
+		value   any
+		want    bool
+		wantErr bool
+	}{
+		{name: "eq", filter: status.Eq("paid"), value: "paid", want: true},
+		{name: "not eq", filter: status.Eq("paid"), value: "new"},
+		{name: "lt", filter: amount.Lt(10), value: int64(9), want: true},
+		{name: "lt equal", filter: amount.Lt(10), value: int64(10)},
+		{name: "lte", filter: amount.Lte(10), value: int64(10), want: true},
+		{name: "gt", filter: amount.Gt(10), value: int64(11), want: true},
+		{name: "gt equal", filter: amount.Gt(10), value: int64(10)},
+		{name: "gte", filter: amount.Gte(10), value: int64(10), want: true},
+		{name: "negative", filter: amount.Gt(-5), value: int64(-4), want: true},
+		{name: "string order", filter: status.Lt("b"), value: "a", want: true},
+		{name: "in", filter: status.In("new", "paid"), value: "paid", want: true},
+		{name: "not in", filter: status.In("new", "paid"), value: "void"},
+		{name: "in empty", filter: status.In(), value: "paid"},
+		{name: "value type", filter: Filter{Column: "amount", Op: OpEq, Values: []any{"10"}}, value: int64(10), wantErr: true},
+		{name: "field type", filter: amount.Eq(1), value: 1, wantErr: true},
+		{name: "no value", filter: Filter{Column: "amount", Op: OpEq}, value: int64(1), wantErr: true},
+		{name: "unknown op", filter: Filter{Column: "amount", Op: "LIKE", Values: []any{int64(1)}}, value: int64(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Match(tt.filter, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match() error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhere(t *testing.T) {
+	columns := map[string]string{"status": `"status"`, "amount": `"amount"`}
+	status := Field[string]{Column: "status"}
+	amount := Field[int64]{Column: "amount"}
+	tests := []struct {
+		name     string
+		filters  []Filter
+		numbered bool
+		first    int
+		where    string
+		args     []any
+		wantErr  bool
+	}{
+		{name: "none", first: 1},
+		{name: "eq", filters: []Filter{status.Eq("paid")}, first: 1, where: `"status" = ?`, args: []any{"paid"}},
+		{
+			name:     "numbered",
+			filters:  []Filter{status.In("new", "paid"), amount.Gte(10)},
+			numbered: true,
+			first:    3,
+			where:    `"status" IN ($3, $4) AND "amount" >= $5`,
+			args:     []any{"new", "paid", int64(10)},
+		},
+		{name: "in empty", filters: []Filter{status.In(), amount.Lt(5)}, numbered: true, first: 1, where: `1 = 0 AND "amount" < $1`, args: []any{int64(5)}},
+		{name: "unknown column", filters: []Filter{Field[string]{Column: "seller_gst"}.Eq("x")}, first: 1, wantErr: true},
+		{name: "no value", filters: []Filter{{Column: "status", Op: OpEq}}, first: 1, wantErr: true},
+		{name: "unknown op", filters: []Filter{{Column: "status", Op: "LIKE", Values: []any{"x"}}}, first: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args, err := Where(tt.filters, columns, tt.numbered, tt.first)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Where() error %v, want error %v", err, tt.wantErr)
+			}
+			if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("Where() = %q, %v; want %q, %v", where, args, tt.where, tt.args)
+			}
+		})
+	}
+}
+
+func TestListQueryBuild(t *testing.T) {
+	q := ListQuery{
+		Select:  `SELECT "id", "status" FROM invoices_view`,
+		Key:     `"id"`,
+		Columns: map[string]string{"status": `"status"`},
+	}
+	numbered := q
+	numbered.Numbered = true
+	status := Field[string]{Column: "status"}
+	tests := []struct {
+		name    string
+		q       ListQuery
+		opts    ListOptions
+		cond    string
+		args    []any
+		query   string
+		want    []any
+		wantErr bool
+	}{
+		{
+			name:  "first page",
+			q:     q,
+			query: `SELECT "id", "status" FROM invoices_view ORDER BY "id" ASC LIMIT ?`,
+			want:  []any{DefaultPageSize + 1},
+		},
+		{
+			name:  "next page",
+			q:     q,
+			opts:  ListOptions{Limit: 2, PageToken: PageToken("inv_2")},
+			query: `SELECT "id", "status" FROM invoices_view WHERE "id" > ? ORDER BY "id" ASC LIMIT ?`,
+			want:  []any{"inv_2", 3},
+		},
+		{
+			name:  "descending",
+			q:     q,
+			opts:  ListOptions{Descending: true, Limit: 2, PageToken: PageToken("inv_2")},
+			query: `SELECT "id", "status" FROM invoices_view WHERE "id" < ? ORDER BY "id" DESC LIMIT ?`,
+			want:  []any{"inv_2", 3},
+		},
+		{
+			name:  "numbered",
+			q:     numbered,
+			opts:  ListOptions{Filters: []Filter{status.Eq("paid")}, Limit: 5, PageToken: PageToken("inv_9")},
+			cond:  `"tenant_id" = $1`,
+			args:  []any{"acme"},
+			query: `SELECT "id", "status" FROM invoices_view WHERE "tenant_id" = $1 AND "status" = $2 AND "id" > $3 ORDER BY "id" ASC LIMIT $4`,
+			want:  []any{"acme", "paid", "inv_9", 6},
+		},
+		{name: "bad token", q: q, opts: ListOptions{PageToken: "!!"}, wantErr: true},
+		{name: "bad filter", q: q, opts: ListOptions{Filters: []Filter{Field[int64]{Column: "amount"}.Eq(1)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := tt.q.Build(tt.opts, tt.cond, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Build() error %v, want error %v", err, tt.wantErr)
+			}
+			if query != tt.query || !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("Build() = %q, %v; want %q, %v", query, args, tt.query, tt.want)
+			}
+		})
+	}
+}
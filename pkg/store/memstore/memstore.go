@@ -0,0 +1,271 @@
+// Package memstore contains the runtime helpers of the in-memory fakes
+// generated next to the SDM repositories. Its tables are not safe for
+// concurrent use; the fakes lock around them.
+package memstore
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+	"github.com/jinuthankachan/sdm/pkg/store"
+)
+
+// DuplicateKeyError reports the insert of a row whose key is taken. It is a
+// unique violation (SQLSTATE 23505), as store.IsUniqueViolation reports.
+type DuplicateKeyError struct {
+	TenantID string
+	Key      string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	if e.TenantID != "" {
+		return fmt.Sprintf("memstore: duplicate key %q of tenant %q", e.Key, e.TenantID)
+	}
+	return fmt.Sprintf("memstore: duplicate key %q", e.Key)
+}
+
+// SQLState returns the SQLSTATE of unique violations.
+func (e *DuplicateKeyError) SQLState() string { return "23505" }
+
+type rowKey struct {
+	tenantID string
+	key      string
+}
+
+// Table is an in-memory PII table of rows of type T, keyed by tenant and
+// record key. The zero value is an empty table.
+type Table[T any] struct {
+	rows map[rowKey]T
+}
+
+// Insert adds the row of the record, or returns a *DuplicateKeyError.
+func (t *Table[T]) Insert(tenantID, key string, row T) error {
+	k := rowKey{tenantID, key}
+	if _, ok := t.rows[k]; ok {
+		return &DuplicateKeyError{TenantID: tenantID, Key: key}
+	}
+	if t.rows == nil {
+		t.rows = make(map[rowKey]T)
+	}
+	t.rows[k] = row
+	return nil
+}
+
+// Put adds or replaces the row of the record.
+func (t *Table[T]) Put(tenantID, key string, row T) {
+	if t.rows == nil {
+		t.rows = make(map[rowKey]T)
+	}
+	t.rows[rowKey{tenantID, key}] = row
+}
+
+// Get returns the row of the record.
+func (t *Table[T]) Get(tenantID, key string) (T, bool) {
+	row, ok := t.rows[rowKey{tenantID, key}]
+	return row, ok
+}
+
+// Keys returns the keys of the records of the tenant in ascending order.
+func (t *Table[T]) Keys(tenantID string) []string {
+	var keys []string
+	for k := range t.rows {
+		if k.tenantID == tenantID {
+			keys = append(keys, k.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// List returns the keys of the page opts selects of the records of the
+// tenant, in the order of their keys, and the token of the next page, as the
+// List of the repositories does. match reports whether a record is listed;
+// the filters of opts are left to it.
+func (t *Table[T]) List(tenantID string, opts store.ListOptions, match func(key string) (bool, error)) ([]string, string, error) {
+	after, paged, err := opts.After()
+	if err != nil {
+		return nil, "", err
+	}
+	keys := t.Keys(tenantID)
+	if opts.Descending {
+		slices.Reverse(keys)
+	}
+	var page []string
+	for _, key := range keys {
+		if paged && (!opts.Descending && key <= after || opts.Descending && key >= after) {
+			continue
+		}
+		ok, err := match(key)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			continue
+		}
+		page = append(page, key)
+		if len(page) > opts.PageSize() {
+			break
+		}
+	}
+	page, next := store.Page(page, opts, func(key string) string { return key })
+	return page, next, nil
+}
+
+// Row is a row of a Chain.
+type Row struct {
+	TenantID   string
+	Key        string
+	FieldName  string
+	Version    int64
+	FieldValue string
+	Status     ledger.Status
+	TxHash     string
+	CreatedAt  time.Time
+}
+
+// Chain is an in-memory chain table: the versions of the chain fields of
+// records, in the order they were written. The zero value is an empty table.
+type Chain struct {
+	rows []Row
+}
+
+// Append writes fields as their next versions of the record, pending and
+// created at now, and returns the fields and versions of the rows written,
+// which Mark takes. Versions count per field, as in the chain tables.
+func (c *Chain) Append(tenantID, key string, fields []ledger.Field, now time.Time) []ledger.FieldVersion {
+	latest := c.Latest(tenantID, key)
+	writes := make([]ledger.FieldVersion, 0, len(fields))
+	for _, field := range fields {
+		version := latest[field.Name].Version + 1
+		c.rows = append(c.rows, Row{
+			TenantID:   tenantID,
+			Key:        key,
+			FieldName:  field.Name,
+			Version:    version,
+			FieldValue: field.Value,
+			Status:     ledger.StatusPending,
+			CreatedAt:  now,
+		})
+		writes = append(writes, ledger.FieldVersion{Field: field.Name, Version: version})
+	}
+	return writes
+}
+
+// Rows returns the rows of the record ordered by version.
+func (c *Chain) Rows(tenantID, key string) []Row {
+	var rows []Row
+	for _, row := range c.rows {
+		if row.TenantID == tenantID && row.Key == key {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Version < rows[j].Version })
+	return rows
+}
+
+// Latest returns the latest row of every field of the record, by field name.
+func (c *Chain) Latest(tenantID, key string) map[string]Row {
+	latest := make(map[string]Row)
+	for _, row := range c.rows {
+		if row.TenantID != tenantID || row.Key != key {
+			continue
+		}
+		if prev, ok := latest[row.FieldName]; !ok || row.Version > prev.Version {
+			latest[row.FieldName] = row
+		}
+	}
+	return latest
+}
+
+// Mark sets the status of the rows of the record written at writes, and
+// their txHash when not empty, and returns the number of rows marked.
+func (c *Chain) Mark(tenantID, key string, writes []ledger.FieldVersion, status ledger.Status, txHash string) int {
+	marked := 0
+	for i, row := range c.rows {
+		if row.TenantID != tenantID || row.Key != key || !slices.Contains(writes, ledger.FieldVersion{Field: row.FieldName, Version: row.Version}) {
+			continue
+		}
+		c.rows[i].Status = status
+		if txHash != "" {
+			c.rows[i].TxHash = txHash
+		}
+		marked++
+	}
+	return marked
+}
+
+// Status returns the ledger status of the record and its latest confirmed
+// transaction, derived from the rows as the views derive them: the least
+// advanced status of the latest version of its fields.
+func (c *Chain) Status(tenantID, key string) (ledger.Status, string) {
+	rank := map[ledger.Status]int{
+		ledger.StatusFailed:    3,
+		ledger.StatusPending:   2,
+		ledger.StatusSubmitted: 1,
+	}
+	latest := c.Latest(tenantID, key)
+	status := ledger.StatusPending
+	if len(latest) > 0 {
+		status = ledger.StatusConfirmed
+		for _, row := range latest {
+			if rank[row.Status] > rank[status] {
+				status = row.Status
+			}
+		}
+	}
+	// Versions count per field: the latest confirmed row is the one written
+	// last, whichever its field.
+	var confirmed *Row
+	for i, row := range c.rows {
+		if row.TenantID != tenantID || row.Key != key || row.Status != ledger.StatusConfirmed {
+			continue
+		}
+		if confirmed == nil || row.CreatedAt.After(confirmed.CreatedAt) ||
+			row.CreatedAt.Equal(confirmed.CreatedAt) && row.Version > confirmed.Version {
+			confirmed = &c.rows[i]
+		}
+	}
+	if confirmed == nil {
+		return status, ""
+	}
+	return status, confirmed.TxHash
+}
+
+// Compact deletes the rows the CompactChain of the repositories deletes:
+// confirmed rows created before the given time and at least keepVersions
+// versions older than the latest confirmed version of their field. It
+// returns the number of rows deleted.
+func (c *Chain) Compact(before time.Time, keepVersions int) int64 {
+	type fieldKey struct {
+		tenantID, key, name string
+	}
+	confirmed := make(map[fieldKey]int64)
+	for _, row := range c.rows {
+		k := fieldKey{row.TenantID, row.Key, row.FieldName}
+		if row.Status == ledger.StatusConfirmed && row.Version > confirmed[k] {
+			confirmed[k] = row.Version
+		}
+	}
+	kept := c.rows[:0]
+	for _, row := range c.rows {
+		latest := confirmed[fieldKey{row.TenantID, row.Key, row.FieldName}]
+		if row.Status == ledger.StatusConfirmed && row.CreatedAt.Before(before) && row.Version <= latest-int64(keepVersions) {
+			continue
+		}
+		kept = append(kept, row)
+	}
+	deleted := int64(len(c.rows) - len(kept))
+	c.rows = kept
+	return deleted
+}
+
+// Int64 returns the integer a chain field value encodes, and 0 for the value
+// of a field without rows, which the views have NULL for.
+func Int64(value string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
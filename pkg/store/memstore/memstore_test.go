@@ -0,0 +1,189 @@
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+	"github.com/jinuthankachan/sdm/pkg/store"
+)
+
+func TestListPages(t *testing.T) {
+	var table Table[int64]
+	var keys []string
+	for i := 1; i <= 6; i++ {
+		key := fmt.Sprintf("inv_%d", i)
+		keys = append(keys, key)
+		if err := table.Insert("acme", key, int64(i*10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table.Put("globex", "inv_0", 0)
+	amount := store.Field[int64]{Column: "amount"}
+
+	tests := []struct {
+		name       string
+		filters    []store.Filter
+		descending bool
+		want       []string
+	}{
+		{name: "all", want: keys},
+		{name: "descending", descending: true, want: []string{"inv_6", "inv_5", "inv_4", "inv_3", "inv_2", "inv_1"}},
+		{name: "filtered", filters: []store.Filter{amount.Gt(20), amount.Lte(50)}, want: []string{"inv_3", "inv_4", "inv_5"}},
+		{name: "in", filters: []store.Filter{amount.In(10, 60)}, descending: true, want: []string{"inv_6", "inv_1"}},
+		{name: "none", filters: []store.Filter{amount.In()}},
+	}
+	for _, tt := range tests {
+		// Page sizes below, at and above the number of rows, and dividing it.
+		for _, limit := range []int{1, 2, 3, 4, 6, 7} {
+			t.Run(fmt.Sprintf("%s/limit %d", tt.name, limit), func(t *testing.T) {
+				opts := store.ListOptions{Filters: tt.filters, Descending: tt.descending, Limit: limit}
+				var got []string
+				for pages := 0; ; pages++ {
+					if pages > len(keys) {
+						t.Fatalf("no last page after %d pages", pages)
+					}
+					page, next, err := table.List("acme", opts, func(key string) (bool, error) {
+						value, _ := table.Get("acme", key)
+						for _, f := range opts.Filters {
+							if ok, err := store.Match(f, value); err != nil || !ok {
+								return false, err
+							}
+						}
+						return true, nil
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page) > limit {
+						t.Fatalf("page of %d rows, limit %d", len(page), limit)
+					}
+					if next != "" && len(page) == 0 {
+						t.Fatalf("empty page with a next token")
+					}
+					got = append(got, page...)
+					if next == "" {
+						break
+					}
+					opts.PageToken = next
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("pages = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	var table Table[string]
+	if _, ok := table.Get("", "a"); ok {
+		t.Fatal("Get() of an empty table found a row")
+	}
+	if err := table.Insert("acme", "b", "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert("acme", "a", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert("globex", "a", "a2"); err != nil {
+		t.Fatalf("Insert() of a key of another tenant: %v", err)
+	}
+	err := table.Insert("acme", "a", "a3")
+	var dup *DuplicateKeyError
+	if !errors.As(err, &dup) || dup.TenantID != "acme" || dup.Key != "a" || !store.IsUniqueViolation(err) {
+		t.Fatalf("Insert() of a taken key = %v, want a unique violation", err)
+	}
+	if row, _ := table.Get("acme", "a"); row != "a1" {
+		t.Errorf("Get() = %q after a duplicate insert, want a1", row)
+	}
+	table.Put("acme", "a", "a4")
+	if row, _ := table.Get("acme", "a"); row != "a4" {
+		t.Errorf("Get() = %q after Put, want a4", row)
+	}
+	if keys := table.Keys("acme"); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("Keys(acme) = %q, want [a b]", keys)
+	}
+	if keys := table.Keys("initech"); keys != nil {
+		t.Errorf("Keys(initech) = %q, want none", keys)
+	}
+}
+
+func TestChainMark(t *testing.T) {
+	var chain Chain
+	now := time.Now()
+	chain.Append("", "a", []ledger.Field{{Name: "amount", Value: "1"}, {Name: "status", Value: "new"}}, now)
+	if writes := chain.Append("", "a", []ledger.Field{{Name: "amount", Value: "2"}}, now); !reflect.DeepEqual(writes, []ledger.FieldVersion{{Field: "amount", Version: 2}}) {
+		t.Fatalf("Append() = %v, want amount version 2", writes)
+	}
+	chain.Append("", "b", []ledger.Field{{Name: "amount", Value: "3"}}, now)
+
+	tests := []struct {
+		name   string
+		writes []ledger.FieldVersion
+		marked int
+		status ledger.Status
+	}{
+		{name: "unknown version", writes: []ledger.FieldVersion{{Field: "amount", Version: 9}}, status: ledger.StatusPending},
+		{name: "one field", writes: []ledger.FieldVersion{{Field: "status", Version: 1}}, marked: 1, status: ledger.StatusPending},
+		{name: "rest", writes: []ledger.FieldVersion{{Field: "amount", Version: 2}}, marked: 1, status: ledger.StatusConfirmed},
+	}
+	for _, tt := range tests {
+		if marked := chain.Mark("", "a", tt.writes, ledger.StatusConfirmed, "0x1"); marked != tt.marked {
+			t.Errorf("%s: Mark() = %d, want %d", tt.name, marked, tt.marked)
+		}
+		if status, _ := chain.Status("", "a"); status != tt.status {
+			t.Errorf("%s: Status() = %s, want %s", tt.name, status, tt.status)
+		}
+	}
+	for _, row := range chain.Rows("", "a") {
+		if row.FieldName == "amount" && row.Version == 1 && row.Status != ledger.StatusPending {
+			t.Errorf("amount version 1 is %s, want pending: only the rows of writes are marked", row.Status)
+		}
+	}
+	if status, _ := chain.Status("", "b"); status != ledger.StatusPending {
+		t.Errorf("Status() of another record = %s, want pending", status)
+	}
+}
+
+func TestChainStatusTx(t *testing.T) {
+	var chain Chain
+	now := time.Now()
+	chain.Append("", "a", []ledger.Field{{Name: "amount", Value: "1"}}, now)
+	chain.Append("", "a", []ledger.Field{{Name: "amount", Value: "2"}}, now.Add(time.Second))
+	chain.Append("", "a", []ledger.Field{{Name: "_deleted", Value: "x"}}, now.Add(2*time.Second))
+	chain.Mark("", "a", []ledger.FieldVersion{{Field: "amount", Version: 1}}, ledger.StatusConfirmed, "0xa")
+	chain.Mark("", "a", []ledger.FieldVersion{{Field: "amount", Version: 2}}, ledger.StatusConfirmed, "0xb")
+	if _, tx := chain.Status("", "a"); tx != "0xb" {
+		t.Errorf("Status() tx = %q, want 0xb", tx)
+	}
+	// Version 1 of _deleted is written after version 2 of amount.
+	chain.Mark("", "a", []ledger.FieldVersion{{Field: "_deleted", Version: 1}}, ledger.StatusConfirmed, "0xc")
+	if status, tx := chain.Status("", "a"); status != ledger.StatusConfirmed || tx != "0xc" {
+		t.Errorf("Status() = %s, %q; want confirmed, 0xc", status, tx)
+	}
+}
+
+func TestListErrors(t *testing.T) {
+	var table Table[int64]
+	table.Put("", "a", 1)
+	errMatch := errors.New("match failed")
+	tests := []struct {
+		name  string
+		opts  store.ListOptions
+		match func(string) (bool, error)
+		want  error
+	}{
+		{name: "bad token", opts: store.ListOptions{PageToken: "!!"}, match: func(string) (bool, error) { return true, nil }},
+		{name: "match", match: func(string) (bool, error) { return false, errMatch }, want: errMatch},
+	}
+	for _, tt := range tests {
+		page, next, err := table.List("", tt.opts, tt.match)
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) || page != nil || next != "" {
+			t.Errorf("%s: List() = %q, %q, %v; want an error", tt.name, page, next, err)
+		}
+	}
+}
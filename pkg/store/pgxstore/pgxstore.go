@@ -0,0 +1,50 @@
+// Package pgxstore contains the runtime helpers of the SDM repositories
+// generated on pgx.
+package pgxstore
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DB is the part of pgx pools, connections and transactions the pgx
+// repositories query with. Repositories on a transaction run in it.
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+var (
+	_ DB = (*pgxpool.Pool)(nil)
+	_ DB = (*pgx.Conn)(nil)
+	_ DB = (pgx.Tx)(nil)
+)
+
+// SendBatch sends b on db and passes its results to read, which reads the
+// result of every query. On a pool or a connection the batch runs as one
+// implicit transaction; on a transaction it runs in a savepoint, so that a
+// failed batch leaves the transaction usable.
+func SendBatch(ctx context.Context, db DB, b *pgx.Batch, read func(pgx.BatchResults) error) error {
+	send := func(db DB) error {
+		results := db.SendBatch(ctx, b)
+		if err := read(results); err != nil {
+			results.Close()
+			return err
+		}
+		return results.Close()
+	}
+	tx, ok := db.(pgx.Tx)
+	if !ok {
+		return send(db)
+	}
+	return pgx.BeginFunc(ctx, tx, func(tx pgx.Tx) error {
+		return send(tx)
+	})
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jinuthankachan/sdm/pkg/ledger"
+)
+
+// Querier is the part of *sql.DB and *sql.Tx the database/sql repositories
+// query with.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var (
+	_ Querier = (*sql.DB)(nil)
+	_ Querier = (*sql.Tx)(nil)
+)
+
+// Transaction runs fn in a transaction of db, committed if fn succeeds and
+// rolled back otherwise.
+func Transaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// InTx runs fn as Transaction does in a transaction of db or, when tx is not
+// nil, in tx: within a savepoint rolled back if fn fails, which leaves tx
+// usable, and released otherwise. Committing tx is left to its owner.
+func InTx(ctx context.Context, db *sql.DB, tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if tx == nil {
+		return Transaction(ctx, db, fn)
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT sdm_tx"); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT sdm_tx")
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT sdm_tx")
+	return err
+}
+
+// Nullable returns a sql.Scanner storing a column into dest, and the zero
+// value of T for NULL, as the view has NULL columns for the fields a record
+// has no chain rows of.
+func Nullable[T any](dest *T) sql.Scanner {
+	return nullable[T]{dest: dest}
+}
+
+type nullable[T any] struct {
+	dest *T
+}
+
+func (n nullable[T]) Scan(src any) error {
+	var v sql.Null[T]
+	if err := v.Scan(src); err != nil {
+		return err
+	}
+	*n.dest = v.V
+	return nil
+}
+
+// Placeholders returns n comma-separated query placeholders: $first,
+// $first+1, ... when numbered, as Postgres takes them, and ? otherwise.
+func Placeholders(numbered bool, first, n int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		if numbered {
+			placeholders[i] = fmt.Sprintf("$%d", first+i)
+		} else {
+			placeholders[i] = "?"
+		}
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// Values returns the VALUES list of a multi-row insert of rows, and its
+// arguments: (?, ?), (?, ?), ... or, when numbered, ($1, $2), ($3, $4), ...
+func Values(numbered bool, rows [][]any) (string, []any) {
+	var args []any
+	values := make([]string, len(rows))
+	for i, row := range rows {
+		values[i] = "(" + Placeholders(numbered, len(args)+1, len(row)) + ")"
+		args = append(args, row...)
+	}
+	return strings.Join(values, ", "), args
+}
+
+// FieldVersions returns the condition matching the chain rows of writes by
+// field_name and version, with placeholders numbered from first on when
+// numbered, and its arguments.
+func FieldVersions(numbered bool, first int, writes []ledger.FieldVersion) (string, []any) {
+	conds := make([]string, len(writes))
+	args := make([]any, 0, 2*len(writes))
+	for i, w := range writes {
+		placeholders := strings.Split(Placeholders(numbered, first+2*i, 2), ", ")
+		conds[i] = fmt.Sprintf("field_name = %s AND version = %s", placeholders[0], placeholders[1])
+		args = append(args, w.Field, w.Version)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
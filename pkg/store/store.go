@@ -0,0 +1,97 @@
+// Package store contains the runtime helpers shared by SDM generated
+// repositories.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ErrVersionConflict reports that a concurrent writer took the chain version
+// a write was about to use.
+var ErrVersionConflict = errors.New("store: chain version conflict")
+
+// ErrConflict reports that the record an Update was about to write was
+// updated by another writer since it was read: its row version moved on.
+var ErrConflict = errors.New("store: record updated concurrently")
+
+// MaxVersionAttempts bounds how many times RetryVersionConflicts runs a write.
+const MaxVersionAttempts = 5
+
+// IsUniqueViolation reports whether err is a unique or primary key
+// violation reported by Postgres, MySQL, SQLite or GORM.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) && state.SQLState() == "23505" {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"duplicate key value",        // Postgres
+		"Duplicate entry",            // MySQL
+		"UNIQUE constraint failed",   // SQLite
+		"duplicated key not allowed", // GORM ErrDuplicatedKey
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionConflict wraps err with ErrVersionConflict if it is a unique
+// violation, and returns it unchanged otherwise. It is applied to the errors
+// of chain row inserts.
+func VersionConflict(err error) error {
+	if IsUniqueViolation(err) {
+		return fmt.Errorf("%w: %v", ErrVersionConflict, err)
+	}
+	return err
+}
+
+// RetryVersionConflicts runs write, typically a whole transaction, until it
+// does not fail with ErrVersionConflict, at most MaxVersionAttempts times.
+func RetryVersionConflicts(ctx context.Context, write func() error) error {
+	var err error
+	for attempt := 1; attempt <= MaxVersionAttempts; attempt++ {
+		err = write()
+		if !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+		backoff := time.Duration(attempt) * time.Duration(5+rand.Intn(10)) * time.Millisecond
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+	return err
+}
+
+// DefaultBatchSize is the number of records the SaveBatch and FetchMany of
+// the repositories write or read per statement unless set otherwise.
+const DefaultBatchSize = 500
+
+// Batches splits items into consecutive batches of at most size items, or
+// DefaultBatchSize items when size is not positive.
+func Batches[T any](items []T, size int) [][]T {
+	if size < 1 {
+		size = DefaultBatchSize
+	}
+	batches := make([][]T, 0, (len(items)+size-1)/size)
+	for len(items) > size {
+		batches = append(batches, items[:size:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		batches = append(batches, items)
+	}
+	return batches
+}
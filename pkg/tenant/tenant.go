@@ -0,0 +1,30 @@
+// Package tenant carries the tenant that SDM generated repositories of
+// multi-tenant messages scope their queries by.
+package tenant
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrMissing reports that a multi-tenant repository was called with a
+// context that carries no tenant.
+var ErrMissing = errors.New("tenant: no tenant in context")
+
+// Setting is the Postgres setting the row-level security policies of
+// multi-tenant tables compare tenant_id with. Roles other than the table
+// owner must set it, e.g. SET sdm.tenant_id = 'acme'.
+const Setting = "sdm.tenant_id"
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying tenant id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// From returns the tenant ctx carries, and whether it carries a non-empty one.
+func From(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
@@ -62,6 +62,30 @@ var file_sdmprotos_annotations_proto_extTypes = []protoimpl.ExtensionInfo{
 		Tag:           "varint,50004,opt,name=hashed",
 		Filename:      "sdmprotos/annotations.proto",
 	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*string)(nil),
+		Field:         50005,
+		Name:          "sdm.references",
+		Tag:           "bytes,50005,opt,name=references",
+		Filename:      "sdmprotos/annotations.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FileOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50100,
+		Name:          "sdm.multi_tenant",
+		Tag:           "varint,50100,opt,name=multi_tenant",
+		Filename:      "sdmprotos/annotations.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FileOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50101,
+		Name:          "sdm.soft_delete",
+		Tag:           "varint,50101,opt,name=soft_delete",
+		Filename:      "sdmprotos/annotations.proto",
+	},
 }
 
 // Extension fields to descriptorpb.FieldOptions.
@@ -76,6 +100,22 @@ var (
 	E_QueryIndex = &file_sdmprotos_annotations_proto_extTypes[3]
 	// optional bool hashed = 50004;
 	E_Hashed = &file_sdmprotos_annotations_proto_extTypes[4]
+	// references names the message of the same file whose primary key the
+	// field holds, e.g. "Invoice".
+	//
+	// optional string references = 50005;
+	E_References = &file_sdmprotos_annotations_proto_extTypes[5]
+)
+
+// Extension fields to descriptorpb.FileOptions.
+var (
+	// optional bool multi_tenant = 50100;
+	E_MultiTenant = &file_sdmprotos_annotations_proto_extTypes[6]
+	// soft_delete gives the messages of the file a Delete that marks their
+	// records deleted_at, keeping them and their chain history, and a Restore.
+	//
+	// optional bool soft_delete = 50101;
+	E_SoftDelete = &file_sdmprotos_annotations_proto_extTypes[7]
 )
 
 var File_sdmprotos_annotations_proto protoreflect.FileDescriptor
@@ -89,10 +129,17 @@ const file_sdmprotos_annotations_proto_rawDesc = "" +
 	"\x03pii\x12\x1d.google.protobuf.FieldOptions\x18҆\x03 \x01(\bR\x03pii:@\n" +
 	"\vquery_index\x12\x1d.google.protobuf.FieldOptions\x18ӆ\x03 \x01(\bR\n" +
 	"queryIndex:7\n" +
-	"\x06hashed\x12\x1d.google.protobuf.FieldOptions\x18Ԇ\x03 \x01(\bR\x06hashedB)Z'github.com/jinuthankachan/sdm/sdmprotosb\x06proto3"
+	"\x06hashed\x12\x1d.google.protobuf.FieldOptions\x18Ԇ\x03 \x01(\bR\x06hashed:?\n" +
+	"\n" +
+	"references\x12\x1d.google.protobuf.FieldOptions\x18Ն\x03 \x01(\tR\n" +
+	"references:A\n" +
+	"\fmulti_tenant\x12\x1c.google.protobuf.FileOptions\x18\xb4\x87\x03 \x01(\bR\vmultiTenant:?\n" +
+	"\vsoft_delete\x12\x1c.google.protobuf.FileOptions\x18\xb5\x87\x03 \x01(\bR\n" +
+	"softDeleteB)Z'github.com/jinuthankachan/sdm/sdmprotosb\x06proto3"
 
 var file_sdmprotos_annotations_proto_goTypes = []any{
 	(*descriptorpb.FieldOptions)(nil), // 0: google.protobuf.FieldOptions
+	(*descriptorpb.FileOptions)(nil),  // 1: google.protobuf.FileOptions
 }
 var file_sdmprotos_annotations_proto_depIdxs = []int32{
 	0, // 0: sdm.primary_key:extendee -> google.protobuf.FieldOptions
@@ -100,10 +147,13 @@ var file_sdmprotos_annotations_proto_depIdxs = []int32{
 	0, // 2: sdm.pii:extendee -> google.protobuf.FieldOptions
 	0, // 3: sdm.query_index:extendee -> google.protobuf.FieldOptions
 	0, // 4: sdm.hashed:extendee -> google.protobuf.FieldOptions
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	0, // [0:5] is the sub-list for extension extendee
+	0, // 5: sdm.references:extendee -> google.protobuf.FieldOptions
+	1, // 6: sdm.multi_tenant:extendee -> google.protobuf.FileOptions
+	1, // 7: sdm.soft_delete:extendee -> google.protobuf.FileOptions
+	8, // [8:8] is the sub-list for method output_type
+	8, // [8:8] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	0, // [0:8] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
 }
 
@@ -119,7 +169,7 @@ func file_sdmprotos_annotations_proto_init() {
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sdmprotos_annotations_proto_rawDesc), len(file_sdmprotos_annotations_proto_rawDesc)),
 			NumEnums:      0,
 			NumMessages:   0,
-			NumExtensions: 5,
+			NumExtensions: 8,
 			NumServices:   0,
 		},
 		GoTypes:           file_sdmprotos_annotations_proto_goTypes,